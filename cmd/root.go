@@ -1,32 +1,487 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/palSagnik/zgrep/utils"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var rootCmd = &cobra.Command{
-	Use: "zgrep",
+	Use:  "zgrep pattern [path ... | -]",
 	Long: "zgrep is a concurrent implementation of GNU grep, taking inspiration from ripgrep in Rust",
-	Args: cobra.ExactArgs(2),
-	Run: func (cmd *cobra.Command, args []string) {
-		pattern := args[0]
-		directory := args[1]
-		
+	Args: func(cmd *cobra.Command, args []string) error {
+		// with -e, -f or --hex every pattern comes from the flags, and
+		// listing files takes none
+		if patternFlags(cmd) || cmd.Flags().Changed("list-files") {
+			return nil
+		}
+		return cobra.MinimumNArgs(1)(cmd, args)
+	},
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// past argument parsing, errors are not usage mistakes
+		cmd.SilenceUsage = true
+
+		// GetStringArray drops a lone empty pattern, which matches
+		// every line, so the values are taken from the flag itself
+		patterns := cmd.Flags().Lookup("pattern").Value.(pflag.SliceValue).GetSlice()
+		patternFiles, _ := cmd.Flags().GetStringArray("file")
+		for _, file := range patternFiles {
+			filePatterns, err := utils.ReadPatterns(file)
+			if err != nil {
+				return err
+			}
+			patterns = append(patterns, filePatterns...)
+		}
+		hexPatterns, _ := cmd.Flags().GetStringArray("hex")
+		for _, h := range hexPatterns {
+			pattern, err := utils.DecodeHex(h)
+			if err != nil {
+				return err
+			}
+			patterns = append(patterns, pattern)
+		}
+		listFiles, _ := cmd.Flags().GetBool("list-files")
+		stats, _ := cmd.Flags().GetBool("stats")
+		progress, _ := cmd.Flags().GetBool("progress")
+		if !patternFlags(cmd) && !listFiles {
+			patterns, args = args[:1], args[1:]
+		}
+		roots := args
+		if len(roots) == 0 {
+			roots = []string{defaultDirectory()}
+		}
+
 		threads, _ := cmd.Flags().GetInt("threads")
-		
-		utils.ConcurrentGrep(pattern, directory, threads)
+		threadsPerCPU, _ := cmd.Flags().GetInt("threads-per-cpu")
+		ioThreads, _ := cmd.Flags().GetInt("threads-io")
+		regex, _ := cmd.Flags().GetBool("regex")
+		if regex && len(hexPatterns) > 0 {
+			// a regular expression matches characters, not arbitrary bytes
+			return fmt.Errorf("--hex patterns are literal and can't be used with --regex")
+		}
+		ignoreCase, _ := cmd.Flags().GetBool("ignore-case")
+		smartCase, _ := cmd.Flags().GetBool("smart-case")
+		unicodeCase, _ := cmd.Flags().GetBool("unicode-case")
+		invert, _ := cmd.Flags().GetBool("invert-match")
+		wordRegexp, _ := cmd.Flags().GetBool("word-regexp")
+		allPatterns, _ := cmd.Flags().GetBool("all")
+		notPatterns, _ := cmd.Flags().GetStringArray("not")
+		lineRegexp, _ := cmd.Flags().GetBool("line-regexp")
+		lineStart, _ := cmd.Flags().GetBool("line-start")
+		lineEnd, _ := cmd.Flags().GetBool("line-end")
+		multiline, _ := cmd.Flags().GetBool("multiline")
+		onlyMatching, _ := cmd.Flags().GetBool("only-matching")
+		overlapping, _ := cmd.Flags().GetBool("overlapping")
+		preFilter, _ := cmd.Flags().GetBool("pre-filter")
+		vimgrep, _ := cmd.Flags().GetBool("vimgrep")
+		maxColumns, _ := cmd.Flags().GetInt("max-columns")
+		fieldSeparator, _ := cmd.Flags().GetString("field-separator")
+		groupSeparator, _ := cmd.Flags().GetString("group-separator")
+		var replace *string
+		if cmd.Flags().Changed("replace") {
+			with, _ := cmd.Flags().GetString("replace")
+			replace = &with
+		}
+		text, _ := cmd.Flags().GetBool("text")
+		encoding, _ := cmd.Flags().GetString("encoding")
+		// like grep, names are only printed when more than one file may
+		// be searched, and always for editors reading --vimgrep
+		noFilename := len(roots) == 1 && !isDir(roots[0]) && !vimgrep
+		if cmd.Flags().Changed("no-filename") || cmd.Flags().Changed("with-filename") {
+			noFilename, _ = cmd.Flags().GetBool("no-filename")
+		}
+		heading, _ := cmd.Flags().GetBool("heading")
+		byteOffset, _ := cmd.Flags().GetBool("byte-offset")
+		count, _ := cmd.Flags().GetBool("count")
+		totalCount, _ := cmd.Flags().GetBool("total-count")
+		includeZero, _ := cmd.Flags().GetBool("include-zero")
+		maxCount, _ := cmd.Flags().GetInt("max-count")
+		maxTotal, _ := cmd.Flags().GetInt("max-total")
+		preview, _ := cmd.Flags().GetInt("preview")
+		quiet, _ := cmd.Flags().GetBool("quiet")
+		filesWithMatches, _ := cmd.Flags().GetBool("files-with-matches")
+		filesWithoutMatch, _ := cmd.Flags().GetBool("files-without-match")
+		nameOnly, _ := cmd.Flags().GetBool("name-only")
+		include, _ := cmd.Flags().GetStringArray("include")
+		exclude, _ := cmd.Flags().GetStringArray("exclude")
+		var path, pathNot *regexp.Regexp
+		if expr, _ := cmd.Flags().GetString("path"); expr != "" {
+			var err error
+			if path, err = regexp.Compile(expr); err != nil {
+				return fmt.Errorf("invalid --path regular expression: %w", err)
+			}
+		}
+		if expr, _ := cmd.Flags().GetString("path-not"); expr != "" {
+			var err error
+			if pathNot, err = regexp.Compile(expr); err != nil {
+				return fmt.Errorf("invalid --path-not regular expression: %w", err)
+			}
+		}
+		types, _ := cmd.Flags().GetStringArray("type")
+		typesNot, _ := cmd.Flags().GetStringArray("type-not")
+		fileTypes := utils.DefaultFileTypes()
+		typeAdds, _ := cmd.Flags().GetStringArray("type-add")
+		for _, spec := range typeAdds {
+			if err := fileTypes.Add(spec); err != nil {
+				return err
+			}
+		}
+		noIgnore, _ := cmd.Flags().GetBool("no-ignore")
+		ignoreFiles, _ := cmd.Flags().GetStringArray("ignore-file")
+		noDecompress, _ := cmd.Flags().GetBool("no-decompress")
+		noDedup, _ := cmd.Flags().GetBool("no-dedup")
+		maxLineSize, _ := cmd.Flags().GetInt("max-line-size")
+		maxOpenFiles, _ := cmd.Flags().GetInt("max-open-files")
+		sortFiles, _ := cmd.Flags().GetBool("sort-files")
+		var sortBy utils.SortKey
+		if s, _ := cmd.Flags().GetString("sort"); s != "" {
+			var err error
+			if sortBy, err = utils.ParseSortKey(s); err != nil {
+				return err
+			}
+			sortFiles = true
+		}
+		walkOrder, _ := cmd.Flags().GetBool("walk-order")
+		hidden, _ := cmd.Flags().GetBool("hidden")
+		follow, _ := cmd.Flags().GetBool("follow")
+		var newerThan, olderThan time.Time
+		now := time.Now()
+		if s, _ := cmd.Flags().GetString("newer-than"); s != "" {
+			var err error
+			if newerThan, err = utils.ParseTime(s, now); err != nil {
+				return err
+			}
+		}
+		if s, _ := cmd.Flags().GetString("older-than"); s != "" {
+			var err error
+			if olderThan, err = utils.ParseTime(s, now); err != nil {
+				return err
+			}
+		}
+		var maxFileSize int64
+		if size, _ := cmd.Flags().GetString("max-filesize"); size != "" {
+			var err error
+			if maxFileSize, err = utils.ParseSize(size); err != nil {
+				return err
+			}
+		}
+		var maxDepth *int
+		if cmd.Flags().Changed("max-depth") {
+			depth, _ := cmd.Flags().GetInt("max-depth")
+			maxDepth = &depth
+		}
+		jsonOutput, _ := cmd.Flags().GetBool("json")
+		jsonEvents, _ := cmd.Flags().GetBool("json-events")
+		colorMode, _ := cmd.Flags().GetString("color")
+		colorSpec, _ := cmd.Flags().GetString("colors")
+		color, err := useColor(colorMode)
+		if err != nil {
+			return err
+		}
+		colors, err := utils.ParseColors(colorSpec)
+		if err != nil {
+			return err
+		}
+		null, _ := cmd.Flags().GetBool("null")
+		column, _ := cmd.Flags().GetBool("column")
+		runeColumn, _ := cmd.Flags().GetBool("rune-column")
+		after, _ := cmd.Flags().GetInt("after-context")
+		before, _ := cmd.Flags().GetInt("before-context")
+
+		// -C sets both sides, unless one was given explicitly
+		if cmd.Flags().Changed("context") {
+			around, _ := cmd.Flags().GetInt("context")
+			if !cmd.Flags().Changed("after-context") {
+				after = around
+			}
+			if !cmd.Flags().Changed("before-context") {
+				before = around
+			}
+		}
+
+		opts := utils.Options{
+			Threads:           threads,
+			ThreadsPerCPU:     threadsPerCPU,
+			IOThreads:         ioThreads,
+			Regex:             regex,
+			IgnoreCase:        ignoreCase,
+			SmartCase:         smartCase,
+			UnicodeCase:       unicodeCase,
+			Invert:            invert,
+			AllPatterns:       allPatterns,
+			NotPatterns:       notPatterns,
+			WordRegexp:        wordRegexp,
+			LineRegexp:        lineRegexp,
+			LineStart:         lineStart,
+			LineEnd:           lineEnd,
+			Multiline:         multiline,
+			Count:             count,
+			TotalCount:        totalCount,
+			IncludeZero:       includeZero,
+			MaxCount:          maxCount,
+			MaxTotal:          maxTotal,
+			Preview:           preview,
+			Quiet:             quiet,
+			FilesWithMatches:  filesWithMatches,
+			FilesWithoutMatch: filesWithoutMatch,
+			NameOnly:          nameOnly,
+			JSON:              jsonOutput,
+			JSONEvents:        jsonEvents,
+			Color:             color,
+			Colors:            colors,
+			Null:              null,
+			Column:            column || runeColumn,
+			RuneColumn:        runeColumn,
+			ByteOffset:        byteOffset,
+			OnlyMatching:      onlyMatching,
+			Overlapping:       overlapping,
+			PreFilter:         preFilter,
+			Vimgrep:           vimgrep,
+			Heading:           heading && !vimgrep,
+			NoFilename:        noFilename,
+			Text:              text,
+			Encoding:          encoding,
+			MaxColumns:        maxColumns,
+			FieldSeparator:    fieldSeparator,
+			GroupSeparator:    groupSeparator,
+			Replace:           replace,
+			Before:            before,
+			After:             after,
+			Include:           include,
+			Exclude:           exclude,
+			Path:              path,
+			PathNot:           pathNot,
+			Types:             types,
+			TypesNot:          typesNot,
+			FileTypes:         fileTypes,
+			Hidden:            hidden,
+			Follow:            follow,
+			MaxDepth:          maxDepth,
+			MaxFileSize:       maxFileSize,
+			NewerThan:         newerThan,
+			OlderThan:         olderThan,
+			Gitignore:         !noIgnore,
+			IgnoreFiles:       ignoreFiles,
+			Decompress:        !noDecompress,
+			Dedup:             !noDedup,
+			MaxLineSize:       maxLineSize,
+			MaxOpenFiles:      maxOpenFiles,
+			SortFiles:         sortFiles,
+			SortBy:            sortBy,
+			WalkOrder:         walkOrder,
+			Output:            cmd.OutOrStdout(),
+			Stats:             stats,
+			Progress:          progress,
+			ErrOutput:         cmd.ErrOrStderr(),
+		}
+		if listFiles {
+			return utils.ListFiles(roots, opts)
+		}
+		// an interrupt stops the search, but what was found so far is
+		// still printed, with a summary. A second one kills zgrep as usual.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			stop()
+		}()
+		defer func() {
+			if ctx.Err() != nil {
+				exitCode = exitInterrupted
+			}
+		}()
+
+		if tail, _ := cmd.Flags().GetBool("tail"); tail {
+			if len(roots) != 1 || roots[0] == "-" {
+				return fmt.Errorf("--tail follows exactly one file")
+			}
+			return utils.Tail(ctx, patterns, roots[0], opts)
+		}
+		if watch, _ := cmd.Flags().GetBool("watch"); watch {
+			return utils.Watch(ctx, patterns, roots, opts)
+		}
+
+		matched, err := utils.ConcurrentGrepContext(ctx, patterns, roots, opts)
+		if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+			return nil
+		}
+		// like grep, a quiet search succeeds on a match despite any errors
+		if quiet && matched {
+			return nil
+		}
+		if err == nil && !matched {
+			exitCode = exitNoMatch
+		}
+		return err
 	},
 }
 
+// Exit statuses, following grep.
+const (
+	exitMatch   = 0
+	exitNoMatch = 1
+	exitError   = 2
+
+	// exitInterrupted is what a shell reports for a process killed by
+	// SIGINT, 128 plus the signal number.
+	exitInterrupted = 130
+)
+
+// exitCode is the status zgrep exits with when it did not fail.
+var exitCode = exitMatch
+
+// useColor decides from a --color mode whether to highlight output. With auto,
+// colour is used when stdout is a terminal and NO_COLOR is not set.
+func useColor(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if _, ok := os.LookupEnv("NO_COLOR"); ok {
+			return false, nil
+		}
+		info, err := os.Stdout.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0, nil
+	}
+	return false, fmt.Errorf("invalid --color %q, want always, never or auto", mode)
+}
+
+// envInt returns the integer value of the environment variable name, or def if
+// it is unset or not a number.
+func envInt(name string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return n
+	}
+	return def
+}
+
+// patternFlags reports whether patterns were given with -e, -f or --hex,
+// in which case the first argument is a path rather than the pattern.
+func patternFlags(cmd *cobra.Command) bool {
+	return cmd.Flags().Changed("pattern") || cmd.Flags().Changed("file") || cmd.Flags().Changed("hex")
+}
+
+// isDir reports whether path is a directory, or a link to one.
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// defaultDirectory is what is searched when no path is given: standard
+// input if something is piped in, otherwise the current directory.
+func defaultDirectory() string {
+	info, err := os.Stdin.Stat()
+	if err == nil && info.Mode()&os.ModeCharDevice == 0 {
+		return "-"
+	}
+	return "."
+}
+
 func Execute() {
-	rootCmd.Flags().IntP("threads", "t", 4, "number of threads to run concurrent processes")
+	rootCmd.Flags().IntP("threads", "t", 0, "number of threads to run concurrent processes, 0 picks one per CPU")
+	rootCmd.Flags().Bool("pre-filter", false, "find literal patterns by scanning for their first byte, faster on long lines where it is rare")
+	rootCmd.Flags().Int("threads-io", 0, "read files into memory on this many threads, leaving --threads to only match, 0 does both on each thread")
+	rootCmd.Flags().Int("threads-per-cpu", envInt("ZGREP_THREADS_PER_CPU", 1), "threads per CPU when --threads is 0, defaults to $ZGREP_THREADS_PER_CPU")
+	rootCmd.Flags().StringArrayP("pattern", "e", nil, "search for this pattern, lines matching any of them are selected (repeatable)")
+	rootCmd.Flags().StringArrayP("file", "f", nil, "read patterns from this file, one per line (repeatable)")
+	rootCmd.Flags().BoolP("multiline", "U", false, "match against whole files so a match can span lines, with . matching newlines in a regex")
+	rootCmd.Flags().Bool("all", false, "select only lines matching every pattern, rather than any of them")
+	rootCmd.Flags().StringArray("not", nil, "never select lines matching this pattern (repeatable)")
+	rootCmd.Flags().StringArray("hex", nil, "search for the bytes written in hex, such as 7f454c46 (repeatable)")
+	rootCmd.Flags().BoolP("regex", "E", false, "treat the pattern as a regular expression")
+	rootCmd.Flags().BoolP("ignore-case", "i", false, "match the pattern without regard to letter case")
+	rootCmd.Flags().Bool("unicode-case", false, "like --ignore-case, but fold the case of every Unicode letter rather than only ASCII ones, which is slower")
+	rootCmd.Flags().BoolP("smart-case", "S", false, "ignore letter case unless the pattern has an upper case letter")
+	rootCmd.Flags().BoolP("invert-match", "v", false, "select lines that do not match the pattern")
+	rootCmd.Flags().BoolP("word-regexp", "w", false, "match the pattern only as a whole word")
+	rootCmd.Flags().BoolP("line-regexp", "x", false, "match the pattern only against the whole line")
+	rootCmd.Flags().Bool("line-start", false, "match the pattern only at the start of the line, like ^ in a regex")
+	rootCmd.Flags().Bool("line-end", false, "match the pattern only at the end of the line, like $ in a regex")
+	rootCmd.Flags().Bool("vimgrep", false, "print every match as file:line:column:text, for editors")
+	rootCmd.Flags().BoolP("byte-offset", "b", false, "print the byte offset in the file of each line, or of each match with -o")
+	rootCmd.Flags().Bool("heading", false, "print the file name once above its matching lines instead of on each one")
+	rootCmd.Flags().BoolP("only-matching", "o", false, "print only the matched parts of selected lines, each on its own line")
+	rootCmd.Flags().Bool("overlapping", false, "find matches of a literal pattern that start inside the one before, so -o prints aa three times for aaaa; -c still counts lines")
+	rootCmd.Flags().BoolP("count", "c", false, "print only a count of selected lines per file")
+	rootCmd.Flags().Bool("total-count", false, "print only the number of selected lines across all files")
+	rootCmd.Flags().Bool("include-zero", false, "with --count, also print files with no selected lines")
+	rootCmd.Flags().IntP("max-count", "m", 0, "stop reading a file after this many selected lines")
+	rootCmd.Flags().Int("max-total", 0, "stop the whole search after this many selected lines across all files")
+	rootCmd.Flags().Int("preview", 0, "print at most this many selected lines per file, then how many more there are")
+	rootCmd.Flags().BoolP("quiet", "q", false, "print nothing, exit with status 0 as soon as a line is selected and 1 if none is")
+	rootCmd.Flags().BoolP("files-with-matches", "l", false, "print only the names of files with a selected line")
+	rootCmd.Flags().BoolP("files-without-match", "L", false, "print only the names of files without a selected line")
+	rootCmd.Flags().Bool("name-only", false, "match the pattern against the path of each file instead of its contents, printing the paths that match")
+	rootCmd.Flags().Bool("json", false, "print each match as a line of JSON")
+	rootCmd.Flags().Bool("json-events", false, "print begin, match, context, end and summary messages in the JSON format of ripgrep --json")
+	rootCmd.Flags().String("color", "auto", "highlight matches: always, never or auto (when printing to a terminal)")
+	rootCmd.Flags().String("colors", os.Getenv("GREP_COLORS"), "colours to use, in GREP_COLORS format such as \"ms=01;31:fn=35\"")
+	rootCmd.Flags().BoolP("null", "Z", false, "follow file names with a NUL byte instead of a newline or \":\"")
+	rootCmd.Flags().Bool("column", false, "print the byte column of the first match on each line")
+	rootCmd.Flags().Bool("rune-column", false, "like --column, but count UTF-8 characters instead of bytes")
+	rootCmd.Flags().BoolP("no-filename", "h", false, "don't print file names, the default when searching a single file")
+	rootCmd.Flags().BoolP("with-filename", "H", false, "print the file name on every line, even when searching a single file")
+	rootCmd.MarkFlagsMutuallyExclusive("no-filename", "with-filename")
+	rootCmd.MarkFlagsMutuallyExclusive("multiline", "invert-match")
+	for _, flag := range []string{"json", "only-matching", "vimgrep", "count", "total-count", "files-with-matches", "files-without-match", "name-only"} {
+		rootCmd.MarkFlagsMutuallyExclusive("json-events", flag)
+	}
+	// -h is taken by --no-filename like in grep, so help is only --help
+	rootCmd.Flags().Bool("help", false, "help for zgrep")
+	rootCmd.Flags().String("encoding", "", "decode every file from this character set, such as latin1 or shift_jis, before searching")
+	rootCmd.Flags().BoolP("text", "a", false, "search binary files as text, printing their matching lines")
+	rootCmd.Flags().String("replace", "", "print each match replaced by this text, with $1 for capture groups in regex mode; files are not changed")
+	rootCmd.Flags().IntP("max-columns", "M", 0, "cut printed lines longer than this many bytes, 0 for no limit")
+	rootCmd.Flags().String("field-separator", ":", "separate the file name, numbers and text of selected lines with this")
+	rootCmd.Flags().String("group-separator", "--", "print this between groups of context lines")
+	rootCmd.Flags().IntP("after-context", "A", 0, "print this many lines of context after each match")
+	rootCmd.Flags().IntP("before-context", "B", 0, "print this many lines of context before each match")
+	rootCmd.Flags().IntP("context", "C", 0, "print this many lines of context around each match")
+	rootCmd.Flags().StringArray("include", nil, "search only files whose name matches this glob (repeatable)")
+	rootCmd.Flags().StringArray("exclude", nil, "skip files whose name matches this glob (repeatable)")
+	rootCmd.Flags().String("path", "", "search only files whose path below the root matches this regular expression")
+	rootCmd.Flags().String("path-not", "", "skip files whose path below the root matches this regular expression, such as '\\.min\\.js$'")
+	rootCmd.Flags().StringArray("type", nil, "search only files of this type, such as go or py (repeatable)")
+	rootCmd.Flags().StringArray("type-not", nil, "skip files of this type (repeatable)")
+	rootCmd.Flags().StringArray("type-add", nil, "add a glob to a file type, creating it if needed, as name:glob such as foo:*.foo (repeatable)")
+	rootCmd.Flags().Bool("hidden", false, "search hidden files and directories too")
+	rootCmd.Flags().Bool("follow", false, "descend into symlinked directories")
+	rootCmd.Flags().Int("max-depth", 0, "descend at most this many directories below the root, 0 searches only its own files")
+	rootCmd.Flags().String("max-filesize", "", "skip files larger than this size, such as 10M or 1G")
+	rootCmd.Flags().String("newer-than", "", "search only files modified since this time, a duration ago such as 24h or an RFC 3339 time")
+	rootCmd.Flags().String("older-than", "", "search only files last modified before this time, a duration ago such as 24h or an RFC 3339 time")
+	rootCmd.Flags().Bool("watch", false, "keep watching for changes after searching, printing new matching lines as they appear")
+	rootCmd.MarkFlagsMutuallyExclusive("name-only", "watch")
+	rootCmd.Flags().Bool("tail", false, "keep reading the file as it grows, like tail -f, printing selected lines as they are appended")
+	rootCmd.Flags().Bool("progress", false, "report the files searched so far and the current directory on stderr every second")
+	rootCmd.Flags().Bool("stats", false, "finish with a summary of the files and lines searched and matched, which an interrupted search prints on stderr anyway")
+	rootCmd.Flags().Bool("list-files", false, "print the files that would be searched without searching them")
+	rootCmd.Flags().Bool("no-ignore", false, "don't skip files listed in .gitignore and .ignore files")
+	rootCmd.Flags().StringArray("ignore-file", nil, "skip files matching the gitignore patterns in this file, can be given more than once")
+	rootCmd.Flags().Bool("sort-files", false, "print results sorted by file path, at the cost of waiting for the whole search")
+	rootCmd.Flags().String("sort", "", "print results sorted by path, modified or size, at the cost of waiting for the whole search")
+	rootCmd.Flags().Bool("walk-order", false, "print results in the order files are found, the same on every run")
+	rootCmd.Flags().Bool("no-dedup", false, "search a file again each time a link or root leads to it")
+	rootCmd.Flags().Bool("no-decompress", false, "search gzip files as they are instead of their decompressed contents")
+	rootCmd.Flags().Int("max-line-size", utils.DefaultMaxLineSize, "longest line in bytes that can be searched")
+	rootCmd.Flags().Int("max-open-files", utils.DefaultMaxOpenFiles, "most files and directories to have open at once")
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "there was error running zgrep: %s\n", err)
-		os.Exit(1)
+		os.Exit(exitError)
 	}
-}
\ No newline at end of file
+	os.Exit(exitCode)
+}
@@ -0,0 +1,132 @@
+package utils
+
+import "bytes"
+
+// ahoCorasickThreshold is the number of literal patterns from which a single
+// ahoCorasick replaces a stringFinder per pattern. Below it the skips of
+// Boyer-Moore usually win over reading every byte once.
+const ahoCorasickThreshold = 8
+
+// ahoCorasick finds any of many literal patterns in one pass over the text,
+// using the Aho-Corasick automaton compiled into a table of transitions for
+// every state and byte.
+type ahoCorasick struct {
+	// classes maps each byte to its column in next. Bytes that appear in
+	// no pattern share column 0, which keeps the table small.
+	classes [256]int32
+	stride  int32
+
+	// next[s*stride+classes[b]] is the state reached from s on byte b,
+	// failure links already followed.
+	next []int32
+
+	// lengths[s] are the lengths of every pattern ending in state s,
+	// including those that are suffixes of the path to it.
+	lengths [][]int
+
+	// maxLen is the length of the longest pattern.
+	maxLen int
+
+	// ignoreCase folds ASCII letters in the text before looking them up.
+	// The patterns are lowercased when the automaton is built.
+	ignoreCase bool
+}
+
+// newAhoCorasick builds the automaton for patterns, matching without regard
+// to ASCII letter case if ignoreCase is set.
+func newAhoCorasick(patterns []string, ignoreCase bool) *ahoCorasick {
+	ac := &ahoCorasick{ignoreCase: ignoreCase}
+
+	lowered := make([][]byte, len(patterns))
+	ac.stride = 1
+	for i, pattern := range patterns {
+		p := []byte(pattern)
+		if ignoreCase {
+			p = bytes.ToLower(p)
+		}
+		lowered[i] = p
+		for _, b := range p {
+			if ac.classes[b] == 0 {
+				ac.classes[b] = ac.stride
+				ac.stride++
+			}
+		}
+	}
+
+	// build the trie, with 0 standing for a missing edge since no edge
+	// leads back to the root
+	ac.next = make([]int32, ac.stride)
+	ac.lengths = make([][]int, 1)
+	for _, p := range lowered {
+		s := int32(0)
+		for _, b := range p {
+			edge := s*ac.stride + ac.classes[b]
+			if ac.next[edge] == 0 {
+				ac.next[edge] = int32(len(ac.lengths))
+				ac.next = append(ac.next, make([]int32, ac.stride)...)
+				ac.lengths = append(ac.lengths, nil)
+			}
+			s = ac.next[edge]
+		}
+		ac.lengths[s] = append(ac.lengths[s], len(p))
+		ac.maxLen = max(ac.maxLen, len(p))
+	}
+
+	// walk the trie breadth first, so the failure state of each state is
+	// complete before its children need it, and fill in the missing edges
+	// from it. Column 0 never has a trie edge, so it always leads back to
+	// the root.
+	fail := make([]int32, len(ac.lengths))
+	var queue []int32
+	for c := int32(1); c < ac.stride; c++ {
+		if child := ac.next[c]; child != 0 {
+			queue = append(queue, child)
+		}
+	}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		ac.lengths[s] = append(ac.lengths[s], ac.lengths[fail[s]]...)
+		for c := int32(1); c < ac.stride; c++ {
+			child := ac.next[s*ac.stride+c]
+			fallback := ac.next[fail[s]*ac.stride+c]
+			if child == 0 {
+				ac.next[s*ac.stride+c] = fallback
+				continue
+			}
+			fail[child] = fallback
+			queue = append(queue, child)
+		}
+	}
+	return ac
+}
+
+// find returns the leftmost match of any of the patterns, preferring the
+// longest when several start at the same offset, like multiMatcher.
+func (ac *ahoCorasick) find(text []byte) (int, int) {
+	start, end := -1, -1
+	// an empty pattern matches before anything is read
+	if len(ac.lengths[0]) > 0 {
+		start, end = 0, 0
+	}
+
+	s := int32(0)
+	for i := 0; i < len(text); i++ {
+		// nothing ending from here on can start early enough to win
+		if start != -1 && i+1-ac.maxLen > start {
+			break
+		}
+
+		b := text[i]
+		if ac.ignoreCase {
+			b = toLower(b)
+		}
+		s = ac.next[s*ac.stride+ac.classes[b]]
+		for _, n := range ac.lengths[s] {
+			if first := i + 1 - n; start == -1 || first < start || first == start && i+1 > end {
+				start, end = first, i+1
+			}
+		}
+	}
+	return start, end
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// identifiers returns n distinct patterns shaped like the identifiers of a
+// -f file listing symbols to find.
+func identifiers(n int) []string {
+	patterns := make([]string, n)
+	for i := range patterns {
+		patterns[i] = fmt.Sprintf("symbol_%04d", i*7919%10000)
+	}
+	return patterns
+}
+
+// finders returns the multiMatcher of one stringFinder per pattern that
+// ahoCorasick replaces from ahoCorasickThreshold patterns.
+func finders(patterns []string) multiMatcher {
+	ms := make(multiMatcher, len(patterns))
+	for i, pattern := range patterns {
+		ms[i] = MakeStringFinder([]byte(pattern))
+	}
+	return ms
+}
+
+var ahoLines = [][]byte{
+	[]byte("plain text with no symbols in it at all, about as long as a line of code"),
+	[]byte("a call to symbol_0000 and one to symbol_7919 near the start of a line"),
+	[]byte("symbol_ prefixes that never finish: symbol_x symbol_y symbol_z symbol_"),
+	[]byte("the last word is a symbol: " + identifiers(300)[299]),
+	[]byte(""),
+}
+
+func TestAhoCorasickMatchesFinders(t *testing.T) {
+	patterns := identifiers(300)
+	ac, ms := newAhoCorasick(patterns, false), finders(patterns)
+	for _, line := range ahoLines {
+		as, ae := ac.find(line)
+		fs, fe := ms.find(line)
+		if as != fs || ae != fe {
+			t.Errorf("find(%q): ahoCorasick %d, %d, finders %d, %d", line, as, ae, fs, fe)
+		}
+	}
+}
+
+// BenchmarkAhoCorasick times one ahoCorasick for many patterns, and
+// BenchmarkFinders one stringFinder per pattern, over the same lines.
+func BenchmarkAhoCorasick(b *testing.B) {
+	for _, n := range []int{ahoCorasickThreshold, 100, 1000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			benchmarkFind(b, newAhoCorasick(identifiers(n), false))
+		})
+	}
+}
+
+func BenchmarkFinders(b *testing.B) {
+	for _, n := range []int{ahoCorasickThreshold, 100, 1000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			benchmarkFind(b, finders(identifiers(n)))
+		})
+	}
+}
+
+func benchmarkFind(b *testing.B, m matcher) {
+	lines := make([][]byte, 0, 1000)
+	size := 0
+	for i := 0; i < cap(lines); i++ {
+		line := ahoLines[i%len(ahoLines)]
+		if i%10 != 1 {
+			// most lines match nothing
+			line = []byte(strings.Repeat("x", len(line)))
+		}
+		lines = append(lines, line)
+		size += len(line) + 1
+	}
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, line := range lines {
+			m.find(line)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// isZip reports whether path is named like a zip archive.
+func isZip(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".zip")
+}
+
+// isTar reports whether path is named like a tar archive, compressed or not.
+func isTar(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasSuffix(lower, ".tar") || strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz")
+}
+
+// scanZip searches every regular, unencrypted member of the zip archive of
+// size bytes read from r, sending a result for each as "path/member". It
+// returns false if the search was cancelled.
+func scanZip(ctx context.Context, r io.ReaderAt, size int64, path string, finder matcher, opts Options, results chan<- fileResult, problems *problems) bool {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		problems.report(fmt.Errorf("error in opening archive %s: %w", path, err))
+		return true
+	}
+
+	for _, member := range archive.File {
+		// bit 0 of the general purpose flags marks an encrypted member,
+		// which archive/zip can't read
+		if !member.Mode().IsRegular() || member.Flags&0x1 != 0 {
+			continue
+		}
+
+		name := path + "/" + member.Name
+		r, err := member.Open()
+		if err != nil {
+			problems.report(fmt.Errorf("error in opening archive member %s: %w", name, err))
+			continue
+		}
+		result, err := scanReader(ctx, r, name, finder, opts)
+		r.Close()
+		if err != nil {
+			problems.report(err)
+		}
+		if !send(ctx, results, result) {
+			return false
+		}
+	}
+	return true
+}
+
+// scanTar searches every regular file in the tar stream r as it is read,
+// sending a result for each as "path/member". Directories, links and other
+// special members are skipped. It returns false if the search was cancelled.
+func scanTar(ctx context.Context, r io.Reader, path string, finder matcher, opts Options, results chan<- fileResult, problems *problems) bool {
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return true
+		}
+		if err != nil {
+			problems.report(fmt.Errorf("error in reading archive %s: %w", path, err))
+			return true
+		}
+		if !header.FileInfo().Mode().IsRegular() {
+			continue
+		}
+
+		name := path + "/" + header.Name
+		result, err := scanReader(ctx, archive, name, finder, opts)
+		if err != nil {
+			problems.report(err)
+		}
+		if !send(ctx, results, result) {
+			return false
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Colors holds the SGR parameters, such as "01;31", used to highlight parts
+// of the output when Options.Color is set. An empty field leaves that part
+// uncoloured.
+type Colors struct {
+	Match      string
+	File       string
+	LineNumber string
+	Separator  string
+}
+
+// DefaultColors are grep's default colours.
+var DefaultColors = Colors{
+	Match:      "01;31",
+	File:       "35",
+	LineNumber: "32",
+	Separator:  "36",
+}
+
+// ParseColors parses a GREP_COLORS style specification such as
+// "ms=01;31:fn=35:ln=32:se=36" on top of DefaultColors. The capabilities
+// understood are mt (or ms) for matches, fn, ln and se; others are ignored so
+// a GREP_COLORS meant for GNU grep can be reused as is.
+func ParseColors(spec string) (Colors, error) {
+	colors := DefaultColors
+	for _, field := range strings.Split(spec, ":") {
+		if field == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		if strings.Trim(value, "0123456789;") != "" {
+			return Colors{}, fmt.Errorf("invalid color %q for %s", value, name)
+		}
+
+		switch name {
+		case "mt", "ms":
+			colors.Match = value
+		case "fn":
+			colors.File = value
+		case "ln":
+			colors.LineNumber = value
+		case "se":
+			colors.Separator = value
+		}
+	}
+	return colors, nil
+}
+
+// paint wraps s in the escape sequences for the SGR parameters sgr.
+func paint(s, sgr string) string {
+	if sgr == "" || s == "" {
+		return s
+	}
+	return "\x1b[" + sgr + "m\x1b[K" + s + "\x1b[m\x1b[K"
+}
+
+// highlight paints each of the spans of line, which must be in order of
+// where they start. Spans that overlap are painted as one.
+func highlight(line string, spans [][2]int, sgr string) string {
+	if len(spans) == 0 || sgr == "" {
+		return line
+	}
+
+	var b strings.Builder
+	last := 0
+	for i := 0; i < len(spans); i++ {
+		start, end := spans[i][0], spans[i][1]
+		for i+1 < len(spans) && spans[i+1][0] < end {
+			i++
+			end = max(end, spans[i][1])
+		}
+		b.WriteString(line[last:start])
+		b.WriteString(paint(line[start:end], sgr))
+		last = end
+	}
+	b.WriteString(line[last:])
+	return b.String()
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+// gzipMagic starts every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decompress returns a reader for the decompressed contents of r if it holds
+// gzip data, recognised by its magic bytes whatever the file is called, or r
+// itself otherwise.
+func decompress(r io.Reader) (io.Reader, error) {
+	magic := make([]byte, len(gzipMagic))
+
+	// regular files can be checked in place, anything else, pipes included,
+	// has to be buffered so the bytes looked at are not lost
+	if ra, ok := r.(io.ReaderAt); ok {
+		n, err := ra.ReadAt(magic, 0)
+		if err == nil || err == io.EOF {
+			if n < len(magic) || !bytes.Equal(magic, gzipMagic) {
+				return r, nil
+			}
+			return gzip.NewReader(r)
+		}
+	}
+
+	br := bufio.NewReader(r)
+	if head, _ := br.Peek(len(gzipMagic)); !bytes.Equal(head, gzipMagic) {
+		return br, nil
+	}
+	return gzip.NewReader(br)
+}
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"io/fs"
+	"sync"
+)
+
+// fileSet remembers the files sent to the workers by their identity rather
+// than their path, so a file reached through links or several roots is only
+// searched once. It is safe for concurrent use.
+type fileSet struct {
+	mu   sync.Mutex
+	seen map[fileID]bool
+}
+
+func newFileSet() *fileSet {
+	return &fileSet{seen: make(map[fileID]bool)}
+}
+
+// add records the file at path, with info as returned by os.Stat, and
+// reports whether it had not been seen before. A file whose identity can't
+// be told is always new.
+func (s *fileSet) add(path string, info fs.FileInfo) bool {
+	id, ok := identify(path, info)
+	if !ok {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seen[id] {
+		return false
+	}
+	s.seen[id] = true
+	return true
+}
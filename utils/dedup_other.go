@@ -0,0 +1,24 @@
+//go:build !unix
+
+package utils
+
+import (
+	"io/fs"
+	"path/filepath"
+)
+
+// fileID is the absolute path of a file with every link resolved. Unlike an
+// inode number it tells hard links apart.
+type fileID string
+
+func identify(path string, info fs.FileInfo) (fileID, bool) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", false
+	}
+	abs, err := filepath.Abs(resolved)
+	if err != nil {
+		return "", false
+	}
+	return fileID(abs), true
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package utils
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileID is a file's device and inode number, the same for every path and
+// hard link leading to it.
+type fileID struct {
+	dev, ino uint64
+}
+
+func identify(path string, info fs.FileInfo) (fileID, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(stat.Dev), ino: stat.Ino}, true
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// the byte order marks UTF-16 text starts with, little and big endian
+var (
+	utf16LEMark = []byte{0xff, 0xfe}
+	utf16BEMark = []byte{0xfe, 0xff}
+)
+
+// decodeUTF16 returns a reader for r transcoded to UTF-8 if it starts with a
+// UTF-16 byte order mark, which is dropped. Without one it returns false and
+// r is left as it was.
+func decodeUTF16(r *bufio.Reader) (io.Reader, bool) {
+	mark, _ := r.Peek(len(utf16LEMark))
+	if !hasUTF16Mark(mark) {
+		return nil, false
+	}
+	decoder := unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM).NewDecoder()
+	return transform.NewReader(r, decoder), true
+}
+
+// hasUTF16Mark reports whether b starts with a UTF-16 byte order mark.
+func hasUTF16Mark(b []byte) bool {
+	return bytes.HasPrefix(b, utf16LEMark) || bytes.HasPrefix(b, utf16BEMark)
+}
+
+// lookupEncoding returns the character set called name, by any of the labels
+// the WHATWG Encoding Standard knows it by, such as "latin1" or "shift_jis".
+func lookupEncoding(name string) (encoding.Encoding, error) {
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown encoding %q", name)
+	}
+	return enc, nil
+}
+
+// checkEncoding returns an error if name is set but is not a known
+// character set.
+func checkEncoding(name string) error {
+	if name == "" {
+		return nil
+	}
+	_, err := lookupEncoding(name)
+	return err
+}
+
+// decodeAs returns a reader for r transcoded to UTF-8 from the character set
+// called name, which must already have been checked with checkEncoding.
+func decodeAs(r io.Reader, name string) io.Reader {
+	enc, _ := lookupEncoding(name)
+	return transform.NewReader(r, enc.NewDecoder())
+}
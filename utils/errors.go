@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// SearchError is returned when some paths could not be walked or read. The
+// results from everything else are still reported, so callers can decide
+// whether a partial search is good enough.
+type SearchError struct {
+	Errs []error
+}
+
+func (e *SearchError) Error() string {
+	if len(e.Errs) == 1 {
+		return "1 path could not be searched"
+	}
+	return fmt.Sprintf("%d paths could not be searched", len(e.Errs))
+}
+
+// Unwrap lets errors.Is and errors.As look at the individual errors.
+func (e *SearchError) Unwrap() []error {
+	return e.Errs
+}
+
+// problems collects the errors met by the walk and the workers, writing each
+// one to w as it happens. It is safe for concurrent use.
+type problems struct {
+	mu   sync.Mutex
+	w    io.Writer
+	errs []error
+}
+
+// newProblems returns a problems writing to opts.ErrOutput, or os.Stderr if
+// it is not set.
+func newProblems(opts Options) *problems {
+	w := opts.ErrOutput
+	if w == nil {
+		w = os.Stderr
+	}
+	return &problems{w: w}
+}
+
+func (p *problems) report(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintln(p.w, err)
+	p.errs = append(p.errs, err)
+}
+
+// note writes msg to w like an error, without it counting as one.
+func (p *problems) note(msg string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintln(p.w, msg)
+}
+
+// err returns the collected errors as a *SearchError, or nil if there were
+// none.
+func (p *problems) err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.errs) == 0 {
+		return nil
+	}
+	return &SearchError{Errs: append([]error(nil), p.errs...)}
+}
@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+// The messages below follow the JSON printed by ripgrep with --json, for
+// Options.JSONEvents. Every message is an object of its type and data.
+type event struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+// eventText is a path or some text in a message, {"text": ...} when it is
+// valid UTF-8 and {"bytes": ...} in base64 otherwise.
+type eventText string
+
+func (t eventText) MarshalJSON() ([]byte, error) {
+	if utf8.ValidString(string(t)) {
+		return json.Marshal(struct {
+			Text string `json:"text"`
+		}{string(t)})
+	}
+	return json.Marshal(struct {
+		Bytes []byte `json:"bytes"`
+	}{[]byte(t)})
+}
+
+type beginData struct {
+	Path eventText `json:"path"`
+}
+
+// lineData is the data of "match" and "context" messages.
+type lineData struct {
+	Path           eventText      `json:"path"`
+	Lines          eventText      `json:"lines"`
+	LineNumber     int            `json:"line_number"`
+	AbsoluteOffset int64          `json:"absolute_offset"`
+	Submatches     []submatchData `json:"submatches"`
+}
+
+type submatchData struct {
+	Match eventText `json:"match"`
+	Start int       `json:"start"`
+	End   int       `json:"end"`
+}
+
+type endData struct {
+	Path         eventText   `json:"path"`
+	BinaryOffset *int64      `json:"binary_offset"`
+	Stats        eventCounts `json:"stats"`
+}
+
+type summaryData struct {
+	ElapsedTotal eventDuration `json:"elapsed_total"`
+	Stats        eventCounts   `json:"stats"`
+}
+
+// eventCounts are the statistics of a file in its "end" message, or of the
+// whole search in the "summary".
+type eventCounts struct {
+	Elapsed           eventDuration `json:"elapsed"`
+	Searches          int           `json:"searches"`
+	SearchesWithMatch int           `json:"searches_with_match"`
+	BytesSearched     int64         `json:"bytes_searched"`
+	BytesPrinted      int64         `json:"bytes_printed"`
+	MatchedLines      int           `json:"matched_lines"`
+	Matches           int           `json:"matches"`
+}
+
+func (c *eventCounts) add(other eventCounts) {
+	c.Searches += other.Searches
+	c.SearchesWithMatch += other.SearchesWithMatch
+	c.BytesSearched += other.BytesSearched
+	c.BytesPrinted += other.BytesPrinted
+	c.MatchedLines += other.MatchedLines
+	c.Matches += other.Matches
+}
+
+type eventDuration struct {
+	Secs  int64  `json:"secs"`
+	Nanos int    `json:"nanos"`
+	Human string `json:"human"`
+}
+
+func newEventDuration(d time.Duration) eventDuration {
+	return eventDuration{
+		Secs:  int64(d / time.Second),
+		Nanos: int(d % time.Second),
+		Human: fmt.Sprintf("%.6fs", d.Seconds()),
+	}
+}
+
+// printEvents writes the messages for one searched file, which are only
+// "begin" and "end" around its lines if it has a selected line.
+func (p *printer) printEvents(result fileResult) error {
+	// files are not timed on their own, only the whole search is
+	counts := eventCounts{Elapsed: newEventDuration(0), Searches: 1, BytesSearched: result.size}
+	if result.count == 0 {
+		p.totals.add(counts)
+		return nil
+	}
+	counts.SearchesWithMatch = 1
+	counts.MatchedLines = result.count
+
+	path := eventText(result.file)
+	n, err := p.event("begin", beginData{Path: path})
+	if err != nil {
+		return err
+	}
+	counts.BytesPrinted += n
+
+	var binaryOffset *int64
+	for _, m := range result.matches {
+		if m.Binary {
+			offset := m.ByteOffset
+			binaryOffset = &offset
+			continue
+		}
+
+		data := lineData{
+			Path:           path,
+			Lines:          eventText(m.Line + m.ending),
+			LineNumber:     m.LineNumber,
+			AbsoluteOffset: m.ByteOffset,
+			Submatches:     []submatchData{},
+		}
+		for _, span := range m.spans {
+			data.Submatches = append(data.Submatches, submatchData{Match: eventText(m.Line[span[0]:span[1]]), Start: span[0], End: span[1]})
+		}
+		kind := "match"
+		if m.Context {
+			kind = "context"
+		} else {
+			counts.Matches += len(data.Submatches)
+		}
+		n, err := p.event(kind, data)
+		if err != nil {
+			return err
+		}
+		counts.BytesPrinted += n
+	}
+
+	if _, err := p.event("end", endData{Path: path, BinaryOffset: binaryOffset, Stats: counts}); err != nil {
+		return err
+	}
+	p.totals.add(counts)
+	return nil
+}
+
+// printSummary writes the "summary" message ending a search that took
+// elapsed.
+func (p *printer) printSummary(elapsed time.Duration) error {
+	counts := p.totals
+	counts.Elapsed = newEventDuration(elapsed)
+	_, err := p.event("summary", summaryData{ElapsedTotal: counts.Elapsed, Stats: counts})
+	return err
+}
+
+// event writes a message of type kind on a line of its own, and returns how
+// many bytes that took.
+func (p *printer) event(kind string, data any) (int64, error) {
+	b, err := json.Marshal(event{Type: kind, Data: data})
+	if err != nil {
+		return 0, err
+	}
+	n, err := p.out.Write(append(b, '\n'))
+	return int64(n), err
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// eventLines returns the "lines" text of every "match" and "context" message
+// printed by a search of content with Options.JSONEvents.
+func eventLines(t *testing.T, content string, opts Options, patterns ...string) []string {
+	t.Helper()
+	opts.JSONEvents = true
+	var lines []string
+	for _, message := range strings.Split(strings.TrimSpace(grepFile(t, content, opts, patterns...)), "\n") {
+		var event struct {
+			Type string
+			Data struct {
+				Lines struct{ Text string }
+			}
+		}
+		if err := json.Unmarshal([]byte(message), &event); err != nil {
+			t.Fatalf("message %q: %v", message, err)
+		}
+		if event.Type == "match" || event.Type == "context" {
+			lines = append(lines, event.Data.Lines.Text)
+		}
+	}
+	return lines
+}
+
+func TestEventLineEndings(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		opts    Options
+		want    []string
+	}{
+		{"newline", "foo\nbar\n", Options{}, []string{"foo\n"}},
+		{"crlf", "foo\r\nbar\r\n", Options{}, []string{"foo\r\n"}},
+		{"no newline at the end", "bar\nfoo", Options{}, []string{"foo"}},
+		{"mixed", "foo\r\nfoo\nfoo", Options{}, []string{"foo\r\n", "foo\n", "foo"}},
+		{"context", "bar\r\nfoo\nbar", Options{Before: 1, After: 1}, []string{"bar\r\n", "foo\n", "bar"}},
+		{"only matching", "a foo\r\n", Options{OnlyMatching: true}, []string{"foo\r\n"}},
+		{"multiline", "a foo\r\nbar\nfoo", Options{Multiline: true}, []string{"a foo\r\n", "foo"}},
+	}
+	for _, tt := range tests {
+		if got := eventLines(t, tt.content, tt.opts, "foo"); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: lines %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestByteLinesEnding(t *testing.T) {
+	lines := &byteLines{data: []byte("a\r\nb\nc"), max: DefaultMaxLineSize}
+	var endings []string
+	for lines.Scan() {
+		endings = append(endings, lines.Ending())
+	}
+	if want := []string{"\r\n", "\n", ""}; !slices.Equal(endings, want) {
+		t.Errorf("endings %q, want %q", endings, want)
+	}
+}
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseSize parses a size in bytes with an optional K, M or G suffix, in
+// powers of 1024, such as "512", "10K" or "1G".
+func ParseSize(s string) (int64, error) {
+	num, multiplier := strings.ToUpper(s), int64(1)
+	switch {
+	case strings.HasSuffix(num, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(num, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(num, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier != 1 {
+		num = num[:len(num)-1]
+	}
+
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// ParseTime parses a point in time given either as a duration before now,
+// such as "24h" or "90m", or as an RFC 3339 timestamp.
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, want a duration such as 24h or an RFC 3339 time", s)
+	}
+	return t, nil
+}
+
+// checkGlobs reports the first malformed pattern in globs, so bad filters are
+// rejected before the walk starts rather than silently matching nothing.
+func checkGlobs(globs []string) error {
+	for _, glob := range globs {
+		if _, err := filepath.Match(glob, ""); err != nil {
+			return fmt.Errorf("invalid glob %q: %w", glob, err)
+		}
+	}
+	return nil
+}
+
+// matchesAny reports whether name matches at least one of globs. The globs
+// must already have been validated with checkGlobs.
+func matchesAny(globs []string, name string) bool {
+	for _, glob := range globs {
+		if ok, _ := filepath.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// wantFile reports whether a file with the given base name passes the include
+// and exclude filters, and those of file types. Exclusions win, and when
+// includes or types are set a file must match one of them.
+func wantFile(opts Options, name string) bool {
+	if matchesAny(opts.Exclude, name) || matchesType(opts, opts.TypesNot, name) {
+		return false
+	}
+	if len(opts.Include) > 0 && !matchesAny(opts.Include, name) {
+		return false
+	}
+	if len(opts.Types) > 0 && !matchesType(opts, opts.Types, name) {
+		return false
+	}
+	return true
+}
+
+// wantPath reports whether a file at relPath, its path below the root it
+// was found under, passes the Path and PathNot filters.
+func wantPath(opts Options, relPath string) bool {
+	relPath = filepath.ToSlash(relPath)
+	if opts.PathNot != nil && opts.PathNot.MatchString(relPath) {
+		return false
+	}
+	if opts.Path != nil && !opts.Path.MatchString(relPath) {
+		return false
+	}
+	return true
+}
+
+// wantName reports whether m selects the file at relPath by its path, for
+// Options.NameOnly.
+func wantName(m matcher, opts Options, relPath string) bool {
+	start, _ := m.find([]byte(filepath.ToSlash(relPath)))
+	return (start != -1) != opts.Invert
+}
+
+// filtersInfo reports whether wantInfo needs to look at a file's size or
+// modification time, so the walk only stats files when it has to.
+func filtersInfo(opts Options) bool {
+	return opts.MaxFileSize > 0 || !opts.NewerThan.IsZero() || !opts.OlderThan.IsZero()
+}
+
+// wantInfo reports whether a file passes the size and modification time
+// filters.
+func wantInfo(opts Options, info fs.FileInfo) bool {
+	if opts.MaxFileSize > 0 && info.Size() > opts.MaxFileSize {
+		return false
+	}
+	if !opts.NewerThan.IsZero() && info.ModTime().Before(opts.NewerThan) {
+		return false
+	}
+	if !opts.OlderThan.IsZero() && info.ModTime().After(opts.OlderThan) {
+		return false
+	}
+	return true
+}
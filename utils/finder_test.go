@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestOverlappingMatches(t *testing.T) {
+	tests := []struct {
+		pattern, text string
+		apart, all    []int
+	}{
+		{"aa", "aaaa", []int{0, 2}, []int{0, 1, 2}},
+		{"aba", "ababa", []int{0}, []int{0, 2}},
+		{"a", "aaa", []int{0, 1, 2}, []int{0, 1, 2}},
+		{"aa", "xaxa", nil, nil},
+		{"", "ab", []int{0, 1, 2}, []int{0, 1, 2}},
+	}
+	for _, tt := range tests {
+		if n := CountMatches([]byte(tt.pattern), []byte(tt.text)); n != len(tt.apart) {
+			t.Errorf("CountMatches(%q, %q) = %d, want %d", tt.pattern, tt.text, n, len(tt.apart))
+		}
+		if n := CountOverlapping([]byte(tt.pattern), []byte(tt.text)); n != len(tt.all) {
+			t.Errorf("CountOverlapping(%q, %q) = %d, want %d", tt.pattern, tt.text, n, len(tt.all))
+		}
+
+		f := MakeStringFinder([]byte(tt.pattern))
+		if got := f.nextAll([]byte(tt.text)); !slices.Equal(got, tt.apart) {
+			t.Errorf("nextAll(%q) in %q = %v, want %v", tt.pattern, tt.text, got, tt.apart)
+		}
+		f.overlapping = true
+		if got := f.nextAll([]byte(tt.text)); !slices.Equal(got, tt.all) {
+			t.Errorf("overlapping nextAll(%q) in %q = %v, want %v", tt.pattern, tt.text, got, tt.all)
+		}
+	}
+}
+
+func TestOverlappingOutput(t *testing.T) {
+	const content = "aaaa\nAAAA\n"
+	replacement := "b"
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"only matching", Options{OnlyMatching: true}, "1:aa\n1:aa\n"},
+		{"only matching overlapping", Options{OnlyMatching: true, Overlapping: true}, "1:aa\n1:aa\n1:aa\n"},
+		{"folded", Options{OnlyMatching: true, Overlapping: true, IgnoreCase: true}, "1:aa\n1:aa\n1:aa\n2:AA\n2:AA\n2:AA\n"},
+		{"unicode folded", Options{OnlyMatching: true, Overlapping: true, UnicodeCase: true}, "1:aa\n1:aa\n1:aa\n2:AA\n2:AA\n2:AA\n"},
+		// counts are of lines, not matches
+		{"count", Options{Count: true, Overlapping: true, IgnoreCase: true}, "2\n"},
+		{"replace", Options{Overlapping: true, Replace: &replacement}, "1:bb\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := grepFile(t, content, tt.opts, "aa"); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// prefilterLines are long lines with the pattern "zebra" at their end, one
+// where its first byte is rare and one where it is on every other byte.
+var prefilterLines = map[string][]byte{
+	"rare":   []byte(strings.Repeat("the quick brown fox jumps over the lazy dog ", 2000) + "zebra"),
+	"common": []byte(strings.Repeat("zaza", 20000) + "zebra"),
+}
+
+func TestPrefilterMatchesNext(t *testing.T) {
+	for name, line := range prefilterLines {
+		plain, pre := MakeStringFinder([]byte("zebra")), MakeStringFinder([]byte("zebra"))
+		pre.prefilter = true
+		want := bytes.LastIndex(line, []byte("zebra"))
+		if i, j := plain.next(line), pre.next(line); i != want || j != want {
+			t.Errorf("%s line: next %d, with prefilter %d, want %d", name, i, j, want)
+		}
+	}
+}
+
+// BenchmarkPrefilter times next with Options.PreFilter, and BenchmarkNext
+// plain Boyer-Moore, over the same long lines.
+func BenchmarkPrefilter(b *testing.B) {
+	benchmarkNext(b, true)
+}
+
+func BenchmarkNext(b *testing.B) {
+	benchmarkNext(b, false)
+}
+
+func benchmarkNext(b *testing.B, prefilter bool) {
+	for _, name := range []string{"rare", "common"} {
+		line := prefilterLines[name]
+		b.Run(name, func(b *testing.B) {
+			f := MakeStringFinder([]byte("zebra"))
+			f.prefilter = prefilter && f.canPrefilter()
+			b.SetBytes(int64(len(line)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				f.next(line)
+			}
+		})
+	}
+}
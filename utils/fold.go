@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+)
+
+// unicodeFinder matches a literal pattern without regard to case across all
+// of Unicode, for Options.UnicodeCase. The pattern and each line are put
+// through full Unicode case folding, so "Ä" matches "ä", "Σ" matches "ς" and
+// "STRASSE" matches "Straße", and matches are mapped back to the bytes of the
+// line they came from.
+type unicodeFinder struct {
+	// folded finds the folded pattern in folded text. It folds ASCII on
+	// its own, so a line of ASCII is searched as it is.
+	folded *stringFinder
+}
+
+func newUnicodeFinder(pattern string) *unicodeFinder {
+	return &unicodeFinder{folded: MakeFoldedStringFinder([]byte(cases.Fold().String(pattern)))}
+}
+
+func (u *unicodeFinder) find(text []byte) (int, int) {
+	if isASCII(text) || len(u.folded.pattern) == 0 {
+		return u.folded.find(text)
+	}
+	folded, starts, ends := foldText(text)
+	i := u.folded.next(folded)
+	if i == -1 {
+		return -1, -1
+	}
+	return starts[i], ends[i+len(u.folded.pattern)-1]
+}
+
+// findAll returns the byte ranges of every match in text, like findAll does
+// for other matchers, folding text only once.
+func (u *unicodeFinder) findAll(text []byte) [][2]int {
+	if isASCII(text) || len(u.folded.pattern) == 0 {
+		var spans [][2]int
+		for _, i := range u.folded.nextAll(text) {
+			spans = append(spans, [2]int{i, i + len(u.folded.pattern)})
+		}
+		return spans
+	}
+
+	folded, starts, ends := foldText(text)
+	var spans [][2]int
+	for _, i := range u.folded.nextAll(folded) {
+		span := [2]int{starts[i], ends[i+len(u.folded.pattern)-1]}
+		// matches within what a single character folds to, such as each
+		// "s" of "ß", are the same match of the line
+		if n := len(spans); n > 0 && (span == spans[n-1] || !u.folded.overlapping && span[0] < spans[n-1][1]) {
+			continue
+		}
+		spans = append(spans, span)
+	}
+	return spans
+}
+
+// foldText returns text with every character case folded, and for each byte
+// of the result where the character it came from starts and ends in text.
+func foldText(text []byte) (folded []byte, starts, ends []int) {
+	caser := cases.Fold()
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRune(text[i:])
+		n := len(folded)
+		switch {
+		case r < utf8.RuneSelf:
+			folded = append(folded, toLower(text[i]))
+		case r == utf8.RuneError && size == 1:
+			folded = append(folded, text[i])
+		default:
+			folded = append(folded, caser.String(string(text[i:i+size]))...)
+		}
+		for ; n < len(folded); n++ {
+			starts = append(starts, i)
+			ends = append(ends, i+size)
+		}
+		i += size
+	}
+	return folded, starts, ends
+}
+
+// isASCII reports whether text holds nothing but ASCII.
+func isASCII(text []byte) bool {
+	for _, b := range text {
+		if b >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,35 @@
+package utils
+
+import "testing"
+
+func TestUnicodeCase(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		pattern string
+		opts    Options
+		want    string
+	}{
+		{"umlaut", "Ärger\närger\nArger\n", "ärger", Options{}, "1:Ärger\n2:ärger\n"},
+		{"umlaut upper pattern", "Ärger\närger\n", "ÄRGER", Options{}, "1:Ärger\n2:ärger\n"},
+		{"sharp s", "Straße\nSTRASSE\nstrasse\n", "straße", Options{}, "1:Straße\n2:STRASSE\n3:strasse\n"},
+		{"sharp s from ss", "Straße\n", "STRASSE", Options{OnlyMatching: true}, "1:Straße\n"},
+		{"sigma", "ΣΟΦΟΣ\nσοφος\nσοφοσ\n", "σοφος", Options{}, "1:ΣΟΦΟΣ\n2:σοφος\n3:σοφοσ\n"},
+		{"final sigma only matching", "οδυσσευς ΟΔΥΣΣΕΥΣ\n", "ΟΔΥΣΣΕΥΣ", Options{OnlyMatching: true}, "1:οδυσσευς\n1:ΟΔΥΣΣΕΥΣ\n"},
+		{"accents are not folded", "Ὀδυσσεύς\n", "οδυσσευς", Options{}, ""},
+		{"sigma in mixed text", "x ς y Σ z\n", "σ", Options{OnlyMatching: true}, "1:ς\n1:Σ\n"},
+		{"smart case keeps upper", "Ärger\närger\n", "Ärger", Options{SmartCase: true}, "1:Ärger\n"},
+		{"ascii -i does not fold", "Ärger\närger\n", "ärger", Options{IgnoreCase: true}, "2:ärger\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			if !opts.IgnoreCase {
+				opts.UnicodeCase = true
+			}
+			if got := grepFile(t, tt.content, opts, tt.pattern); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
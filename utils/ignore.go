@@ -0,0 +1,195 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ignoreRule is a single pattern line from a gitignore-style file.
+type ignoreRule struct {
+	// segments is the pattern split on "/", with any leading or trailing
+	// slash removed.
+	segments []string
+
+	// base is the slash-separated directory, relative to the search root,
+	// containing the file the rule came from. It is empty for the root.
+	base string
+
+	// negate re-includes paths matched by an earlier rule ("!pattern").
+	negate bool
+
+	// dirOnly restricts the rule to directories ("pattern/").
+	dirOnly bool
+
+	// anchored rules contain a slash and are matched against the path
+	// relative to base. Unanchored rules match the base name at any depth.
+	anchored bool
+}
+
+// ignoreFileNames are the ignore files looked for in every directory with
+// Options.Gitignore, in the order they are loaded, so the rules of the last
+// win over those of the first.
+var ignoreFileNames = []string{".gitignore", ".ignore"}
+
+// ignoreMatcher decides which paths are ignored from the rules of every
+// ignore file seen so far. Rules are kept in the order they were added and
+// the last matching rule wins, so rules from deeper directories, which are
+// loaded later by the walk, take precedence over those of their parents.
+// Rules of sibling directories may be loaded in any order, but never apply to
+// the same paths. It is safe for concurrent use.
+type ignoreMatcher struct {
+	mu    sync.RWMutex
+	rules []ignoreRule
+}
+
+// parseIgnoreRule parses one line of an ignore file. It returns false for
+// blank lines and comments.
+func parseIgnoreRule(line, base string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		// "\#" and "\!" escape a literal leading character
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimLeft(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	rule.segments = strings.Split(line, "/")
+	return rule, true
+}
+
+// readIgnoreFile parses the ignore file at file, whose rules apply below
+// base.
+func readIgnoreFile(file, base string) ([]ignoreRule, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(scanner.Text(), base); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// readIgnoreFiles parses the ignore files given with Options.IgnoreFiles,
+// whose rules apply to everything below the search root.
+func readIgnoreFiles(files []string) ([]ignoreRule, error) {
+	var rules []ignoreRule
+	for _, file := range files {
+		fileRules, err := readIgnoreFile(file, "")
+		if err != nil {
+			return nil, fmt.Errorf("error in reading ignore file: %w", err)
+		}
+		rules = append(rules, fileRules...)
+	}
+	return rules, nil
+}
+
+// load adds the rules from the ignore file at file, whose directory is base
+// relative to the search root. A missing file is not an error.
+func (m *ignoreMatcher) load(file, base string) error {
+	rules, err := readIgnoreFile(file, base)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	m.mu.Lock()
+	m.rules = append(m.rules, rules...)
+	m.mu.Unlock()
+	return err
+}
+
+// ignored reports whether relPath, a path relative to the search root, is
+// ignored by the loaded rules.
+func (m *ignoreMatcher) ignored(relPath string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.rules) == 0 {
+		return false
+	}
+	relPath = filepath.ToSlash(relPath)
+
+	ignored := false
+	for _, rule := range m.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.matches(relPath) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+func (r ignoreRule) matches(relPath string) bool {
+	// rules only apply below the directory of the file they came from
+	if r.base != "" {
+		if !strings.HasPrefix(relPath, r.base+"/") {
+			return false
+		}
+		relPath = relPath[len(r.base)+1:]
+	}
+
+	if !r.anchored {
+		ok, _ := path.Match(r.segments[0], path.Base(relPath))
+		return ok
+	}
+	return matchSegments(r.segments, strings.Split(relPath, "/"))
+}
+
+// matchSegments matches a path against a pattern one segment at a time,
+// where a "**" segment matches zero or more whole path segments.
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// try every possible number of segments for the wildcard
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,530 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// ReadPatterns loads one pattern per line from the file at path. Blank lines
+// are ignored.
+func ReadPatterns(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error in opening pattern file: %w", err)
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		pattern := strings.TrimSuffix(scanner.Text(), "\r")
+		if pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error in reading pattern file %s: %w", path, err)
+	}
+	return patterns, nil
+}
+
+// DecodeHex turns a pattern written in hexadecimal, such as "DEADBEEF" or
+// "0x7f 45 4c 46", into the bytes it stands for, so binary signatures can be
+// searched for as literals. Spaces between the bytes are allowed.
+func DecodeHex(s string) (string, error) {
+	digits := strings.Join(strings.Fields(s), "")
+	digits = strings.TrimPrefix(strings.TrimPrefix(digits, "0x"), "0X")
+	b, err := hex.DecodeString(digits)
+	if err != nil {
+		return "", fmt.Errorf("invalid hex pattern %q: %w", s, err)
+	}
+	return string(b), nil
+}
+
+// matcher is implemented by the search engines a worker can use. One matcher
+// is shared by all the workers of a search, so find must not change it.
+type matcher interface {
+	// find returns the start and end offsets of the first match in text, or
+	// -1, -1 if there is none.
+	find(text []byte) (int, int)
+}
+
+// fromFinder is a matcher whose matches depend on the text before them, such
+// as one for whole lines. Cutting that off to look further on would change
+// what it matches, so it is told where to start looking instead.
+type fromFinder interface {
+	// findFrom returns the first match in text starting at from or later,
+	// like find on text[from:] but with offsets into text.
+	findFrom(text []byte, from int) (int, int)
+}
+
+// findFrom returns the first match of m in text starting at from or later.
+func findFrom(m matcher, text []byte, from int) (int, int) {
+	if f, ok := m.(fromFinder); ok {
+		return f.findFrom(text, from)
+	}
+	start, end := m.find(text[from:])
+	if start == -1 {
+		return -1, -1
+	}
+	return start + from, end + from
+}
+
+// regexMatcher adapts a compiled regular expression to the matcher interface.
+// A *regexp.Regexp is safe for concurrent use, so workers share one.
+type regexMatcher struct {
+	re *regexp.Regexp
+}
+
+func (m regexMatcher) find(text []byte) (int, int) {
+	loc := m.re.FindIndex(text)
+	if loc == nil {
+		return -1, -1
+	}
+	return loc[0], loc[1]
+}
+
+// newMatcher returns the matcher for patterns, compiled to res if they are
+// regexes, selecting lines matched by any of them or, with AllPatterns, by
+// all of them, and none of Options.NotPatterns.
+func newMatcher(patterns []string, res []*regexp.Regexp, opts Options) matcher {
+	m := anyMatcher(patterns, res, opts)
+	if opts.AllPatterns && len(patterns) > 1 {
+		all := allMatcher{any: m}
+		for i := range patterns {
+			var re []*regexp.Regexp
+			if res != nil {
+				re = res[i : i+1]
+			}
+			all.each = append(all.each, anyMatcher(patterns[i:i+1], re, opts))
+		}
+		m = all
+	}
+
+	if len(opts.NotPatterns) > 0 {
+		// a bad pattern has already been reported by compilePatterns
+		notRes, _ := compileRegexes(opts.NotPatterns, opts)
+		m = notMatcher{m: m, not: anyMatcher(opts.NotPatterns, notRes, opts)}
+	}
+	return m
+}
+
+// anyMatcher returns a matcher for each pattern, combined into one that
+// matches any of them. Patterns use the shared regexes if they were compiled,
+// otherwise a stringFinder is made for each. Matchers are restricted to whole
+// lines or words when opts asks for it. Many literal patterns are searched
+// for together by an ahoCorasick instead.
+func anyMatcher(patterns []string, res []*regexp.Regexp, opts Options) matcher {
+	// a whole word match needs every pattern tried on its own, as the
+	// longest match at a position may not be a word where a shorter one is
+	if res == nil && !opts.WordRegexp && !opts.UnicodeCase && !opts.Overlapping && len(patterns) >= ahoCorasickThreshold && sameFold(patterns, opts) {
+		var m matcher = newAhoCorasick(patterns, foldCase(patterns[0], opts))
+		if opts.LineRegexp {
+			m = lineMatcher{m: m}
+		}
+		return anchored(m, opts)
+	}
+
+	matchers := make(multiMatcher, len(patterns))
+	for i, pattern := range patterns {
+		var m matcher
+		if res != nil && opts.WordRegexp && !opts.LineRegexp {
+			m = newWordRegexMatcher(res[i])
+		} else if res != nil {
+			m = regexMatcher{re: res[i]}
+		} else if foldCase(pattern, opts) && opts.UnicodeCase {
+			u := newUnicodeFinder(pattern)
+			u.folded.overlapping = opts.Overlapping
+			m = u
+		} else {
+			var f *stringFinder
+			if foldCase(pattern, opts) {
+				f = MakeFoldedStringFinder([]byte(pattern))
+			} else {
+				f = MakeStringFinder([]byte(pattern))
+			}
+			f.overlapping = opts.Overlapping
+			f.prefilter = opts.PreFilter && f.canPrefilter()
+			m = f
+		}
+
+		if opts.LineRegexp {
+			// regexes are anchored when they are compiled
+			if res == nil {
+				m = lineMatcher{m: m}
+			}
+		} else if opts.WordRegexp && res == nil {
+			m = wordMatcher{m: m}
+		}
+		if res == nil {
+			m = anchored(m, opts)
+		}
+		matchers[i] = m
+	}
+
+	if len(matchers) == 1 {
+		return matchers[0]
+	}
+	return matchers
+}
+
+// foldCase reports whether pattern is matched without regard to case.
+func foldCase(pattern string, opts Options) bool {
+	if opts.IgnoreCase {
+		return true
+	}
+	if opts.SmartCase {
+		return !hasUpper(pattern, opts.Regex)
+	}
+	return opts.UnicodeCase
+}
+
+// sameFold reports whether all patterns agree on whether case is folded.
+func sameFold(patterns []string, opts Options) bool {
+	for _, pattern := range patterns[1:] {
+		if foldCase(pattern, opts) != foldCase(patterns[0], opts) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasUpper reports whether pattern has an upper case letter. In a regex the
+// letter after a backslash is an escape such as \S or \W, not text.
+func hasUpper(pattern string, regex bool) bool {
+	escaped := false
+	for _, r := range pattern {
+		if escaped {
+			escaped = false
+			continue
+		}
+		if regex && r == '\\' {
+			escaped = true
+			continue
+		}
+		if unicode.IsUpper(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// multiMatcher matches any one of several matchers.
+type multiMatcher []matcher
+
+// find returns the leftmost match of any of the matchers, preferring the
+// longest when several start at the same offset.
+func (ms multiMatcher) find(text []byte) (int, int) {
+	return ms.findFrom(text, 0)
+}
+
+func (ms multiMatcher) findFrom(text []byte, from int) (int, int) {
+	start, end := -1, -1
+	for _, m := range ms {
+		s, e := findFrom(m, text, from)
+		if s == -1 {
+			continue
+		}
+		if start == -1 || s < start || s == start && e > end {
+			start, end = s, e
+		}
+		if start == from && end == len(text) {
+			// nothing can beat a match of the whole line
+			break
+		}
+	}
+	return start, end
+}
+
+// findAll returns the byte ranges of every non-overlapping match of m in
+// text, leftmost first. Literal patterns matched with Options.Overlapping
+// may overlap.
+func findAll(m matcher, text []byte) [][2]int {
+	// the combinations are only asked once the line is known to be
+	// selected, when every match of what was searched for counts
+	switch c := m.(type) {
+	case allMatcher:
+		return findAll(c.any, text)
+	case notMatcher:
+		return findAll(c.m, text)
+	}
+
+	// a regex knows best where its later matches are, anchors included
+	if r, ok := m.(regexMatcher); ok {
+		var spans [][2]int
+		for _, loc := range r.re.FindAllIndex(text, -1) {
+			spans = append(spans, [2]int{loc[0], loc[1]})
+		}
+		return spans
+	}
+	if u, ok := m.(*unicodeFinder); ok {
+		return u.findAll(text)
+	}
+	if f, ok := m.(*stringFinder); ok {
+		var spans [][2]int
+		for _, i := range f.nextAll(text) {
+			spans = append(spans, [2]int{i, i + len(f.pattern)})
+		}
+		return spans
+	}
+
+	var spans [][2]int
+	offset := 0
+	for offset <= len(text) {
+		start, end := findFrom(m, text, offset)
+		if start == -1 {
+			break
+		}
+		spans = append(spans, [2]int{start, end})
+		if end == start {
+			// step over an empty match so the loop always progresses
+			end++
+		}
+		offset = end
+	}
+	return spans
+}
+
+// allMatcher matches where any does, but only in text matched by every one
+// of each.
+type allMatcher struct {
+	any  matcher
+	each []matcher
+}
+
+func (a allMatcher) find(text []byte) (int, int) {
+	for _, m := range a.each {
+		if start, _ := m.find(text); start == -1 {
+			return -1, -1
+		}
+	}
+	return a.any.find(text)
+}
+
+// notMatcher matches where m does, but never in text that not matches.
+type notMatcher struct {
+	m   matcher
+	not matcher
+}
+
+func (n notMatcher) find(text []byte) (int, int) {
+	if start, _ := n.not.find(text); start != -1 {
+		return -1, -1
+	}
+	return n.m.find(text)
+}
+
+// wordMatcher only accepts matches of m that are whole words, that is, not
+// preceded or followed by a word character. It is meant for literal
+// patterns, which match the same wherever the search for them starts; a
+// regex is matched as a word by a wordRegexMatcher.
+type wordMatcher struct {
+	m matcher
+}
+
+func (w wordMatcher) find(text []byte) (int, int) {
+	return w.findFrom(text, 0)
+}
+
+func (w wordMatcher) findFrom(text []byte, from int) (int, int) {
+	for from <= len(text) {
+		start, end := findFrom(w.m, text, from)
+		if start == -1 {
+			return -1, -1
+		}
+		if (start == 0 || !isWordByte(text[start-1])) && (end == len(text) || !isWordByte(text[end])) {
+			return start, end
+		}
+		// a later occurrence may still stand on its own
+		from = start + 1
+	}
+	return -1, -1
+}
+
+// wordRegexMatcher matches a regex only as a whole word, with the bounds of
+// the word built into the regexes it runs. Checking the bounds of each match
+// afterwards instead would miss a longer alternative that is a word, as in
+// "foo|foobar" against "foobar", and searching again from after where a
+// match was turned down would let "^" match there.
+type wordRegexMatcher struct {
+	// re is the pattern on its own, whose capture groups are those of a
+	// match.
+	re *regexp.Regexp
+
+	// first finds a word at the start of text or after a non-word byte,
+	// and later only after a non-word byte, for searching from the byte
+	// before where a match may start. Group 1 is the match of re.
+	first, later *regexp.Regexp
+}
+
+func newWordRegexMatcher(re *regexp.Regexp) wordRegexMatcher {
+	// re compiled, so wrapping it in groups does too
+	return wordRegexMatcher{
+		re:    re,
+		first: regexp.MustCompile(`(?:^|\W)(` + re.String() + `)(?:\W|$)`),
+		later: regexp.MustCompile(`\W(` + re.String() + `)(?:\W|$)`),
+	}
+}
+
+func (w wordRegexMatcher) find(text []byte) (int, int) {
+	return w.findFrom(text, 0)
+}
+
+func (w wordRegexMatcher) findFrom(text []byte, from int) (int, int) {
+	_, loc := w.submatchFrom(text, from)
+	if loc == nil {
+		return -1, -1
+	}
+	return loc[0], loc[1]
+}
+
+// submatchFrom returns the groups of re from those of the wrapping regex,
+// where group 1 is the whole match of re.
+func (w wordRegexMatcher) submatchFrom(text []byte, from int) (*regexp.Regexp, []int) {
+	re, offset := w.first, 0
+	if from > 0 {
+		re, offset = w.later, from-1
+	}
+	loc := re.FindSubmatchIndex(text[offset:])
+	if loc == nil {
+		return nil, nil
+	}
+	return w.re, shiftLoc(loc[2:], offset)
+}
+
+// lineMatcher only accepts a match of m that covers a whole line. It is
+// meant for literal patterns, whose first match from the start of a line is
+// the whole line whenever the line equals the pattern. With Multiline, text
+// holds many lines, and each is tried in turn.
+type lineMatcher struct {
+	m matcher
+}
+
+func (l lineMatcher) find(text []byte) (int, int) {
+	return l.findFrom(text, 0)
+}
+
+func (l lineMatcher) findFrom(text []byte, from int) (int, int) {
+	for from <= len(text) {
+		start, end := findFrom(l.m, text, from)
+		if start == -1 {
+			return -1, -1
+		}
+		if atLineStart(text, start) && atLineEnd(text, end) && !pastLastLine(text, start) {
+			return start, end
+		}
+		// only a match from the start of a later line can be a whole one
+		if from = nextLine(text, start); from == -1 {
+			return -1, -1
+		}
+	}
+	return -1, -1
+}
+
+// anchored restricts m, a matcher of literal patterns, to the start or the
+// end of the line as opts asks.
+func anchored(m matcher, opts Options) matcher {
+	switch {
+	case opts.LineRegexp:
+		return m
+	case opts.LineStart && opts.LineEnd:
+		return lineMatcher{m: m}
+	case opts.LineStart:
+		return startMatcher{m: m}
+	case opts.LineEnd:
+		return endMatcher{m: m}
+	}
+	return m
+}
+
+// startMatcher only accepts a match of m at the start of a line, which is
+// its first match from there if there is one.
+type startMatcher struct {
+	m matcher
+}
+
+func (s startMatcher) find(text []byte) (int, int) {
+	return s.findFrom(text, 0)
+}
+
+func (s startMatcher) findFrom(text []byte, from int) (int, int) {
+	for from <= len(text) {
+		start, end := findFrom(s.m, text, from)
+		if start == -1 {
+			return -1, -1
+		}
+		if atLineStart(text, start) && !pastLastLine(text, start) {
+			return start, end
+		}
+		if from = nextLine(text, start); from == -1 {
+			return -1, -1
+		}
+	}
+	return -1, -1
+}
+
+// endMatcher only accepts a match of m at the end of a line, looking past
+// the earlier ones.
+type endMatcher struct {
+	m matcher
+}
+
+func (e endMatcher) find(text []byte) (int, int) {
+	return e.findFrom(text, 0)
+}
+
+func (e endMatcher) findFrom(text []byte, from int) (int, int) {
+	for from <= len(text) {
+		start, end := findFrom(e.m, text, from)
+		if start == -1 {
+			return -1, -1
+		}
+		if atLineEnd(text, end) && !pastLastLine(text, start) {
+			return start, end
+		}
+		from = start + 1
+	}
+	return -1, -1
+}
+
+// atLineStart reports whether offset i of text is at the start of a line.
+// Text without a newline, as searched line by line, is a single line.
+func atLineStart(text []byte, i int) bool {
+	return i == 0 || text[i-1] == '\n'
+}
+
+// atLineEnd reports whether offset i of text is at the end of a line, before
+// its "\n" or "\r\n" ending if it has one.
+func atLineEnd(text []byte, i int) bool {
+	if i == len(text) || text[i] == '\n' {
+		return true
+	}
+	return text[i] == '\r' && (i+1 == len(text) || text[i+1] == '\n')
+}
+
+// pastLastLine reports whether offset i of text is after the newline ending
+// it, where there is no line left to match.
+func pastLastLine(text []byte, i int) bool {
+	return i == len(text) && i > 0 && text[i-1] == '\n'
+}
+
+// nextLine returns the offset in text of the start of the line after the one
+// offset i is on, or -1 if that line is the last.
+func nextLine(text []byte, i int) int {
+	n := bytes.IndexByte(text[i:], '\n')
+	if n == -1 {
+		return -1
+	}
+	return i + n + 1
+}
+
+// isWordByte reports whether b is in [A-Za-z0-9_].
+func isWordByte(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9' || b == '_'
+}
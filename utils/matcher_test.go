@@ -0,0 +1,90 @@
+package utils
+
+import "testing"
+
+func TestMultilineLineRegexp(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		pattern string
+		opts    Options
+		want    string
+	}{
+		{"literal", "foo\nbar\nfoo\n", "foo", Options{}, "1:foo\n3:foo\n"},
+		{"regex", "foo\nbar\nfoo\n", "foo", Options{Regex: true}, "1:foo\n3:foo\n"},
+		{"only matching", "foo\nbar\nfoo\n", "foo", Options{OnlyMatching: true}, "1:foo\n3:foo\n"},
+		{"part of a line first", "xfoo\nfoox\nfoo", "foo", Options{}, "3:foo\n"},
+		{"crlf", "foo\r\nbar\r\nfoo\r\n", "foo", Options{}, "1:foo\n3:foo\n"},
+		{"empty line", "a\n\nb\n", "", Options{}, "2:\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.Multiline, opts.LineRegexp = true, true
+			if got := grepFile(t, tt.content, opts, tt.pattern); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultilineLineStartEnd(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		opts    Options
+		want    string
+	}{
+		{"start", "foo\nbar\nfoo\n", Options{LineStart: true}, "2:bar\n"},
+		{"start not first", "xbar\nbarx\n", Options{LineStart: true}, "2:barx\n"},
+		{"end", "bar\nfoobar\nbarfoo\n", Options{LineEnd: true}, "1:bar\n2:foobar\n"},
+		{"end crlf", "bar\r\nbarx\r\n", Options{LineEnd: true}, "1:bar\n"},
+		{"end without newline", "foo\nbar", Options{LineEnd: true}, "2:bar\n"},
+		{"start and end", "barbar\nbar\n", Options{LineStart: true, LineEnd: true}, "2:bar\n"},
+		{"start only matching", "bar bar\nbar\n", Options{LineStart: true, OnlyMatching: true}, "1:bar\n2:bar\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.Multiline = true
+			if got := grepFile(t, tt.content, opts, "bar"); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineStartOnlyMatching(t *testing.T) {
+	// a later match is not at the start of the line just because the
+	// search for it starts after the first
+	if got := grepFile(t, "barbar\n", Options{LineStart: true, OnlyMatching: true}, "bar"); got != "1:bar\n" {
+		t.Errorf("got %q, want %q", got, "1:bar\n")
+	}
+}
+
+func TestWordRegexp(t *testing.T) {
+	template := "<$1>"
+	tests := []struct {
+		name    string
+		content string
+		pattern string
+		opts    Options
+		want    string
+	}{
+		{"longer alternative", "foobar\n", "foo|foobar", Options{Regex: true}, "1:foobar\n"},
+		{"caret after a rejected match", "x-b\n", "-b|^b", Options{Regex: true, OnlyMatching: true}, ""},
+		{"adjacent words", "foo foo,foo\n", "fo+", Options{Regex: true, OnlyMatching: true}, "1:foo\n1:foo\n1:foo\n"},
+		{"capture groups", "foo foobar\n", "(f)oo", Options{Regex: true, Replace: &template}, "1:<f> foobar\n"},
+		{"ignore case", "FOO food\n", "foo", Options{Regex: true, IgnoreCase: true, OnlyMatching: true}, "1:FOO\n"},
+		{"literal after a word byte", "-x-x\n", "-x", Options{OnlyMatching: true}, "1:-x\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.WordRegexp = true
+			if got := grepFile(t, tt.content, opts, tt.pattern); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
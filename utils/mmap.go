@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime/debug"
+)
+
+// mmapThreshold is the size from which a file is searched through a mapping
+// of it into memory rather than read through a buffer. For smaller files
+// setting up the mapping costs more than copying saves. It is a variable only
+// so tests can lower it.
+var mmapThreshold int64 = 32 << 20
+
+// scanMapped searches f like scanReader, but reads its lines straight out of
+// a mapping of the file into memory, without copying them. It returns false
+// without reading anything if f is too small to be worth mapping or can't be
+// mapped.
+func scanMapped(ctx context.Context, f *os.File, name string, finder matcher, opts Options) (fileResult, bool, error) {
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() || info.Size() < mmapThreshold {
+		return fileResult{}, false, nil
+	}
+	data, unmap, ok := mapFile(f, info.Size())
+	if !ok {
+		return fileResult{}, false, nil
+	}
+	defer unmap()
+	result, err := scanMappedBytes(ctx, data, name, finder, opts)
+	return result, true, err
+}
+
+// errShrunk is why a mapped file could not be searched to the end: it got
+// shorter while it was being read, as a log truncated by its rotation does,
+// and reading past its new end faults.
+var errShrunk = errors.New("file shrank while being read")
+
+// scanMappedBytes is scanBytes on data mapped from the file called name,
+// returning an error for that file instead of crashing if the file shrinks
+// under the mapping.
+func scanMappedBytes(ctx context.Context, data []byte, name string, finder matcher, opts Options) (result fileResult, err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if faulted(recover()) {
+			result, err = fileResult{file: name}, shrunkError(name)
+		}
+	}()
+	return scanBytes(ctx, data, name, finder, opts)
+}
+
+// faulted reports whether r, a value recovered from a panic, is the fault
+// of reading memory that is no longer backed by a file. It panics again with
+// anything else. Only goroutines that have called debug.SetPanicOnFault get
+// to recover from such a fault.
+func faulted(r any) bool {
+	if r == nil {
+		return false
+	}
+	if _, ok := r.(interface{ Addr() uintptr }); ok {
+		return true
+	}
+	panic(r)
+}
+
+func shrunkError(name string) error {
+	return fmt.Errorf("error in reading file %s: %w", name, errShrunk)
+}
+
+// scanBytes searches data, the whole contents of a file, like scanReader.
+func scanBytes(ctx context.Context, data []byte, name string, finder matcher, opts Options) (fileResult, error) {
+	// UTF-16 text, or text in the charset asked for, has to be decoded
+	// first
+	if opts.Encoding != "" || hasUTF16Mark(data) {
+		return scanReader(ctx, bytes.NewReader(data), name, finder, opts)
+	}
+
+	if opts.Multiline {
+		return scanMultiline(ctx, data, name, finder, opts), nil
+	}
+
+	head := data[:min(len(data), binaryPeekSize)]
+	isBinary := !opts.Text && bytes.IndexByte(head, 0) != -1
+	if pieces := splitLines(data, pieceCount(len(data), opts)); len(pieces) > 1 {
+		return scanPieces(ctx, data, pieces, name, finder, opts, isBinary)
+	}
+	return scanLinesOf(ctx, &byteLines{data: data, max: opts.MaxLineSize}, name, finder, opts, isBinary, 1, 0)
+}
+
+// byteLines is a lineScanner over the contents of a file in memory, splitting
+// them with scanLines. Lines longer than max are an error, as they are for
+// bufio.Scanner.
+type byteLines struct {
+	data   []byte
+	line   []byte
+	size   int64
+	ending string
+	max    int
+	err    error
+}
+
+func (l *byteLines) Scan() bool {
+	if l.err != nil || len(l.data) == 0 {
+		return false
+	}
+	n, line, _ := scanLines(l.data, true)
+	if len(line) > l.max {
+		l.err = bufio.ErrTooLong
+		return false
+	}
+	l.line, l.size, l.ending, l.data = line, int64(n), lineEnding(l.data[len(line):n]), l.data[n:]
+	return true
+}
+
+func (l *byteLines) Bytes() []byte {
+	return l.line
+}
+
+func (l *byteLines) Size() int64 {
+	return l.size
+}
+
+func (l *byteLines) Ending() string {
+	return l.ending
+}
+
+func (l *byteLines) Err() error {
+	return l.err
+}
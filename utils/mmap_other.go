@@ -0,0 +1,11 @@
+//go:build !unix
+
+package utils
+
+import "os"
+
+// mapFile never maps anything where there is no mmap, so files are always
+// read through a buffer.
+func mapFile(f *os.File, size int64) ([]byte, func(), bool) {
+	return nil, nil, false
+}
@@ -0,0 +1,175 @@
+//go:build unix
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mappedFile writes content to a file and maps it, returning the path and
+// the mapping, which is released when the test ends.
+func mappedFile(t testing.TB, content []byte) (string, []byte) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mapped")
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	data, unmap, ok := mapFile(f, int64(len(content)))
+	if !ok {
+		t.Fatal("mapFile failed")
+	}
+	t.Cleanup(unmap)
+	return path, data
+}
+
+func TestScanMappedTruncated(t *testing.T) {
+	content := bytes.Repeat([]byte("a line without the pattern\n"), 1<<16)
+	opts := scanDefaults(Options{Threads: 4})
+	finder := MakeStringFinder([]byte("needle"))
+
+	tests := []struct {
+		name string
+		scan func(data []byte, starts []int, name string) (fileResult, error)
+	}{
+		{"whole", func(data []byte, starts []int, name string) (fileResult, error) {
+			return scanMappedBytes(context.Background(), data, name, finder, opts)
+		}},
+		{"pieces", func(data []byte, starts []int, name string) (fileResult, error) {
+			return scanPieces(context.Background(), data, starts, name, finder, opts, false)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, data := mappedFile(t, content)
+			starts := splitLines(data, 4)
+			// as a log rotated with copytruncate would be
+			if err := os.Truncate(path, 0); err != nil {
+				t.Fatal(err)
+			}
+			result, err := tt.scan(data, starts, path)
+			if !errors.Is(err, errShrunk) {
+				t.Fatalf("got error %v, want %v", err, errShrunk)
+			}
+			if result.file != path {
+				t.Errorf("got result for %q, want %q", result.file, path)
+			}
+		})
+	}
+}
+
+// benchFileSize is the size of the file the mapped and buffered searches are
+// timed on, large enough that the page cache and not setup dominates.
+const benchFileSize = 2 << 30
+
+var (
+	benchFileOnce sync.Once
+	benchFilePath string
+)
+
+// benchFile returns the path of a file of benchFileSize bytes of log lines
+// without the pattern, written once for all the benchmarks.
+func benchFile(b *testing.B) string {
+	benchFileOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "zgrep-bench")
+		if err != nil {
+			b.Fatal(err)
+		}
+		path := filepath.Join(dir, "big.log")
+		line := "2024-01-01T00:00:00Z INFO request served in 12ms from 10.0.0.1\n"
+		chunk := []byte(strings.Repeat(line, (1<<20)/len(line)))
+		f, err := os.Create(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for written := 0; written < benchFileSize; written += len(chunk) {
+			if _, err := f.Write(chunk); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := f.Close(); err != nil {
+			b.Fatal(err)
+		}
+		benchFilePath = path
+	})
+	if benchFilePath == "" {
+		b.Skip("no file to search")
+	}
+	return benchFilePath
+}
+
+// TestMain removes the file written for the benchmarks, if any.
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if benchFilePath != "" {
+		os.RemoveAll(filepath.Dir(benchFilePath))
+	}
+	os.Exit(code)
+}
+
+func benchmarkSearchFile(b *testing.B, mapped bool) {
+	path := benchFile(b)
+	info, err := os.Stat(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	opts := scanDefaults(Options{Threads: 1})
+	finder := MakeStringFinder([]byte("needle"))
+	b.SetBytes(info.Size())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f, err := os.Open(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if mapped {
+			_, ok, err := scanMapped(context.Background(), f, path, finder, opts)
+			if !ok || err != nil {
+				b.Fatalf("scanMapped: %v, %v", ok, err)
+			}
+		} else if _, err := scanReader(context.Background(), f, path, finder, opts); err != nil {
+			b.Fatal(err)
+		}
+		f.Close()
+	}
+}
+
+// BenchmarkSearchMapped and BenchmarkSearchRead compare searching a large
+// file through a mapping with reading it through a buffer.
+func BenchmarkSearchMapped(b *testing.B) { benchmarkSearchFile(b, true) }
+func BenchmarkSearchRead(b *testing.B)   { benchmarkSearchFile(b, false) }
+
+func TestCancelIOThreadsMapped(t *testing.T) {
+	defer func(threshold int64) { mmapThreshold = threshold }(mmapThreshold)
+	mmapThreshold = 64 << 10
+
+	// the readers search every file themselves, and are still at it when
+	// the first of them finds the match at the end of its file and the
+	// search is stopped
+	dir := t.TempDir()
+	content := append(bytes.Repeat([]byte("a line without the pattern\n"), 128<<10), "the needle\n"...)
+	for i := 0; i < 8; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("big%02d", i)), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		matched, err := ConcurrentGrep([]string{"needle"}, []string{dir}, Options{Quiet: true, Threads: 1, IOThreads: 4, Output: io.Discard})
+		if err != nil || !matched {
+			t.Fatalf("ConcurrentGrep = %v, %v, want true, nil", matched, err)
+		}
+	}
+}
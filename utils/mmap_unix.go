@@ -0,0 +1,23 @@
+//go:build unix
+
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps the first size bytes of f into memory read only, returning
+// the mapping and a function to release it, or false if it can't be mapped.
+// Reading past the end of the file, if it shrinks while it is mapped,
+// faults, which scanMappedBytes turns into an error.
+func mapFile(f *os.File, size int64) ([]byte, func(), bool) {
+	if int64(int(size)) != size {
+		return nil, nil, false
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, false
+	}
+	return data, func() { syscall.Munmap(data) }, true
+}
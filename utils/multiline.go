@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"unicode/utf8"
+)
+
+// scanMultiline searches data, the whole contents of a file, for matches
+// that may span lines, for Options.Multiline. Every line a match touches is
+// reported, numbered as usual, or with OnlyMatching and Vimgrep each match
+// once at the line it starts on.
+func scanMultiline(ctx context.Context, data []byte, name string, finder matcher, opts Options) fileResult {
+	result := fileResult{file: name, size: int64(len(data))}
+	isBinary := !opts.Text && bytes.IndexByte(data, 0) != -1
+
+	// lineNumber is that of the line starting at lineStart, the one the
+	// last match looked at started on
+	lineNumber, lineStart := 1, 0
+	firstMatch := -1
+	// a replaced match needs the capture groups it was found with
+	var rs []replaced
+	var spans [][2]int
+	if opts.OnlyMatching && opts.Replace != nil {
+		rs = replacements(finder, data)
+		for _, r := range rs {
+			spans = append(spans, r.span)
+		}
+	} else {
+		spans = findAll(finder, data)
+	}
+	for n, span := range spans {
+		if done(ctx) || opts.MaxCount > 0 && result.count >= opts.MaxCount {
+			break
+		}
+		if firstMatch == -1 {
+			firstMatch = span[0]
+		}
+		for {
+			i := bytes.IndexByte(data[lineStart:span[0]], '\n')
+			if i == -1 {
+				break
+			}
+			lineStart += i + 1
+			lineNumber++
+		}
+
+		if opts.OnlyMatching || opts.Vimgrep {
+			line := lineAt(data, lineStart)
+			m := Match{File: name, LineNumber: lineNumber, ByteOffset: int64(lineStart), Line: string(line), Column: span[0] - lineStart + 1, ending: endingAt(data, lineStart+len(line))}
+			if opts.RuneColumn {
+				m.Column = utf8.RuneCount(data[lineStart:span[0]]) + 1
+			}
+			if opts.OnlyMatching {
+				m.ByteOffset, m.Line = int64(span[0]), string(data[span[0]:span[1]])
+				if opts.Replace != nil {
+					m.Line = string(rs[n].expand(nil, data, *opts.Replace))
+				}
+			} else if wantSpans(opts) {
+				m.spans = [][2]int{{span[0] - lineStart, min(span[1]-lineStart, len(line))}}
+			}
+			result.matches = append(result.matches, m)
+			result.count++
+			continue
+		}
+
+		// report each line of the match, adding to the last one reported
+		// if an earlier match ended on it
+		start, end := lineStart, lineNumber
+		for {
+			line := lineAt(data, start)
+			clipped := [2]int{max(span[0], start) - start, min(span[1]-start, len(line))}
+			if n := len(result.matches); n > 0 && result.matches[n-1].LineNumber == end {
+				if wantSpans(opts) {
+					result.matches[n-1].spans = append(result.matches[n-1].spans, clipped)
+				}
+			} else {
+				// like any other selected line, one that only part of a
+				// match is on counts towards MaxCount
+				if opts.MaxCount > 0 && result.count >= opts.MaxCount {
+					break
+				}
+				m := Match{File: name, LineNumber: end, ByteOffset: int64(start), Line: string(line), ending: endingAt(data, start+len(line))}
+				if start == lineStart {
+					m.Column = span[0] - lineStart + 1
+					if opts.RuneColumn {
+						m.Column = utf8.RuneCount(data[lineStart:span[0]]) + 1
+					}
+				}
+				if wantSpans(opts) {
+					m.spans = [][2]int{clipped}
+				}
+				result.matches = append(result.matches, m)
+				result.count++
+			}
+
+			// the match ends on this line if its last byte, the newline
+			// included, is not past it
+			next := bytes.IndexByte(data[start:], '\n')
+			if next == -1 || span[1]-1 <= start+next {
+				break
+			}
+			start += next + 1
+			end++
+		}
+	}
+
+	if isBinary && result.count > 0 && !opts.Count {
+		result.matches = []Match{{File: name, ByteOffset: int64(firstMatch), Binary: true}}
+	}
+	return result
+}
+
+// lineAt returns the line of data starting at start, without its ending.
+func lineAt(data []byte, start int) []byte {
+	line := data[start:]
+	if i := bytes.IndexByte(line, '\n'); i != -1 {
+		line = line[:i]
+	}
+	return bytes.TrimSuffix(line, []byte("\r"))
+}
+
+// endingAt returns the ending of the line of data that lineAt cut off at end.
+func endingAt(data []byte, end int) string {
+	rest := data[end:]
+	if i := bytes.IndexByte(rest, '\n'); i != -1 {
+		rest = rest[:i+1]
+	}
+	return lineEnding(rest)
+}
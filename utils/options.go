@@ -0,0 +1,138 @@
+package utils
+
+import "context"
+
+// Option changes one setting of SearchRoots or SearchStream. Options are
+// applied in order, so a later one overrides an earlier one.
+type Option func(*searchConfig)
+
+// searchConfig is everything an Option can set.
+type searchConfig struct {
+	ctx      context.Context
+	patterns []string
+	opts     Options
+}
+
+// WithOptions replaces every setting held in Options with opts.
+func WithOptions(opts Options) Option {
+	return func(c *searchConfig) {
+		c.opts = opts
+	}
+}
+
+// WithContext stops the search early when ctx is cancelled.
+func WithContext(ctx context.Context) Option {
+	return func(c *searchConfig) {
+		c.ctx = ctx
+	}
+}
+
+// WithPatterns selects lines matching any of patterns as well as the pattern
+// given to SearchRoots.
+func WithPatterns(patterns ...string) Option {
+	return func(c *searchConfig) {
+		c.patterns = append(c.patterns, patterns...)
+	}
+}
+
+// WithThreads sets Options.Threads.
+func WithThreads(n int) Option {
+	return func(c *searchConfig) {
+		c.opts.Threads = n
+	}
+}
+
+// WithRegex sets Options.Regex.
+func WithRegex() Option {
+	return func(c *searchConfig) {
+		c.opts.Regex = true
+	}
+}
+
+// WithIgnoreCase sets Options.IgnoreCase.
+func WithIgnoreCase() Option {
+	return func(c *searchConfig) {
+		c.opts.IgnoreCase = true
+	}
+}
+
+// WithWordRegexp sets Options.WordRegexp.
+func WithWordRegexp() Option {
+	return func(c *searchConfig) {
+		c.opts.WordRegexp = true
+	}
+}
+
+// WithInvert sets Options.Invert.
+func WithInvert() Option {
+	return func(c *searchConfig) {
+		c.opts.Invert = true
+	}
+}
+
+// WithContextLines sets Options.Before and Options.After.
+func WithContextLines(before, after int) Option {
+	return func(c *searchConfig) {
+		c.opts.Before, c.opts.After = before, after
+	}
+}
+
+// SearchRoots returns every line under roots selected by pattern. A root of
+// "-" searches Options.Stdin instead. If the context given with WithContext is
+// cancelled, the matches found so far are returned along with its error.
+// Otherwise, if some paths could not be searched, the matches from the rest
+// are returned with a *SearchError.
+func SearchRoots(pattern string, roots []string, opts ...Option) ([]Match, error) {
+	c := searchConfig{ctx: context.Background(), patterns: []string{pattern}}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return searchMatches(c.ctx, c.patterns, roots, c.opts)
+}
+
+// SearchStream is like SearchRoots under a single root, but sends each line
+// on the returned channel as soon as its file has been searched. Once the
+// search is over the matches channel is closed, and the error SearchRoots
+// would have returned, if any, is sent on the error channel before it is
+// closed too. A caller that stops reading early must cancel the context
+// given with WithContext so the search can wind down.
+func SearchStream(pattern, dir string, opts ...Option) (<-chan Match, <-chan error) {
+	c := searchConfig{ctx: context.Background(), patterns: []string{pattern}}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	matches := make(chan Match)
+	errc := make(chan error, 1)
+
+	results, problems, err := search(c.ctx, c.patterns, []string{dir}, everyLine(c.opts))
+	if err != nil {
+		close(matches)
+		errc <- err
+		close(errc)
+		return matches, errc
+	}
+
+	go func() {
+		defer close(errc)
+		// results is drained even once the caller has gone, so the
+		// workers are never left blocked
+		for result := range results {
+			for _, m := range result.matches {
+				select {
+				case matches <- m:
+				case <-c.ctx.Done():
+				}
+			}
+		}
+		close(matches)
+
+		if err := c.ctx.Err(); err != nil {
+			errc <- err
+		} else if err := problems.err(); err != nil {
+			errc <- err
+		}
+	}()
+	return matches, errc
+}
@@ -0,0 +1,249 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// printer writes the results of ConcurrentGrep in the format opts asks for.
+// Output is buffered, it is up to the caller to flush out.
+type printer struct {
+	out  *bufio.Writer
+	enc  *json.Encoder
+	opts Options
+
+	// with context, groups of lines that don't follow on from the previous
+	// line printed are separated by Options.GroupSeparator, "--" like grep
+	withContext bool
+	last        Match
+	printed     bool
+
+	// headed is set once a file heading has been printed.
+	headed bool
+
+	// totals add up the files printed with Options.JSONEvents.
+	totals eventCounts
+}
+
+func newPrinter(w io.Writer, opts Options) *printer {
+	if opts.FieldSeparator == "" {
+		opts.FieldSeparator = ":"
+	}
+	if opts.GroupSeparator == "" {
+		opts.GroupSeparator = "--"
+	}
+	out := bufio.NewWriterSize(w, 64<<10)
+	return &printer{
+		out:         out,
+		enc:         json.NewEncoder(out),
+		opts:        opts,
+		withContext: (opts.Before > 0 || opts.After > 0) && !opts.OnlyMatching && !opts.Vimgrep,
+	}
+}
+
+// print writes everything to be reported about one searched file.
+func (p *printer) print(result fileResult) error {
+	opts := p.opts
+	if opts.JSONEvents {
+		return p.printEvents(result)
+	}
+
+	// the total is printed on its own once every file is counted
+	if opts.TotalCount {
+		return nil
+	}
+
+	if opts.FilesWithMatches || opts.NameOnly {
+		if result.count > 0 {
+			fmt.Fprint(p.out, formatName(result.file, opts))
+		}
+		return nil
+	}
+
+	if opts.FilesWithoutMatch {
+		if result.count == 0 {
+			fmt.Fprint(p.out, formatName(result.file, opts))
+		}
+		return nil
+	}
+
+	if opts.Count {
+		if result.count > 0 || opts.IncludeZero {
+			fmt.Fprint(p.out, formatCount(result.file, result.count, opts))
+		}
+		return nil
+	}
+
+	// grouped output puts the file name on a line of its own above its
+	// lines, with a blank line between files
+	if opts.Heading && !opts.NoFilename && !opts.JSON && len(result.matches) > 0 {
+		if p.headed {
+			fmt.Fprintln(p.out)
+		}
+		fmt.Fprintln(p.out, fileName(result.file, opts))
+		p.headed = true
+		// context groups never run on from the file before
+		p.printed = false
+	}
+
+	for _, m := range result.matches {
+		if opts.JSON {
+			if err := p.enc.Encode(m); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !m.Binary {
+			if p.withContext && p.printed && (m.File != p.last.File || m.LineNumber != p.last.LineNumber+1) {
+				fmt.Fprintln(p.out, separator(opts.GroupSeparator, opts))
+			}
+			p.last, p.printed = m, true
+		}
+		fmt.Fprint(p.out, formatMatch(m, opts))
+	}
+	if result.hidden > 0 && !opts.JSON {
+		fmt.Fprintf(p.out, "(+%d more)\n", result.hidden)
+	}
+	return nil
+}
+
+// searchStats adds up the results of a search for Options.Stats.
+type searchStats struct {
+	start        time.Time
+	files        int
+	matchedFiles int
+	lines        int
+}
+
+func (s *searchStats) add(result fileResult) {
+	s.files++
+	if result.count > 0 {
+		s.matchedFiles++
+	}
+	s.lines += result.count
+}
+
+// format renders the summary printed at the end of a search that took
+// elapsed.
+func (s *searchStats) format(elapsed time.Duration) string {
+	return fmt.Sprintf("\n%d matched lines\n%d files contained matches\n%d files searched\n%.6f seconds\n",
+		s.lines, s.matchedFiles, s.files, elapsed.Seconds())
+}
+
+// formatName renders a file name on its own, as listed by FilesWithMatches
+// and FilesWithoutMatch. With Null it is terminated by a NUL byte instead of
+// a newline so names containing newlines survive "xargs -0".
+func formatName(name string, opts Options) string {
+	name = fileName(name, opts)
+	if opts.Null {
+		return name + "\x00"
+	}
+	return name + "\n"
+}
+
+// fileSeparator returns what follows the file name on a line of output, sep
+// normally or a NUL byte with Null.
+func fileSeparator(sep string, opts Options) string {
+	if opts.Null {
+		return "\x00"
+	}
+	return separator(sep, opts)
+}
+
+// separator renders the separator sep between two fields of a line.
+func separator(sep string, opts Options) string {
+	if opts.Color {
+		return paint(sep, opts.Colors.Separator)
+	}
+	return sep
+}
+
+// fileName renders a file name at the start of a line.
+func fileName(name string, opts Options) string {
+	if opts.Color {
+		return paint(name, opts.Colors.File)
+	}
+	return name
+}
+
+// lineNumber renders a line or column number.
+func lineNumber(n int, opts Options) string {
+	if opts.Color {
+		return paint(strconv.Itoa(n), opts.Colors.LineNumber)
+	}
+	return strconv.Itoa(n)
+}
+
+// formatCount renders the number of selected lines in a file.
+func formatCount(name string, count int, opts Options) string {
+	if opts.NoFilename {
+		return fmt.Sprintf("%d\n", count)
+	}
+	return fmt.Sprintf("%s%s%d\n", fileName(name, opts), fileSeparator(opts.FieldSeparator, opts), count)
+}
+
+// formatMatch renders a selected or context line. Selected lines use
+// Options.FieldSeparator to separate their fields, context lines use "-" like
+// grep.
+func formatMatch(m Match, opts Options) string {
+	if m.Binary {
+		return fmt.Sprintf("Binary file %s matches at byte %d\n", m.File, m.ByteOffset)
+	}
+
+	sep := opts.FieldSeparator
+	if m.Context {
+		sep = "-"
+	}
+	line, spans, cut := truncate(m.Line, m.spans, opts.MaxColumns)
+	if opts.Color {
+		line = highlight(line, spans, opts.Colors.Match)
+	}
+	if cut {
+		line += truncationMarker
+	}
+
+	// under a heading the file name has already been printed
+	prefix := ""
+	if !opts.Heading && !opts.NoFilename {
+		prefix = fileName(m.File, opts) + fileSeparator(sep, opts)
+	}
+
+	fields := prefix + lineNumber(m.LineNumber, opts) + separator(sep, opts)
+	if (opts.Column || opts.Vimgrep) && m.Column > 0 {
+		fields += lineNumber(m.Column, opts) + separator(sep, opts)
+	}
+	if opts.ByteOffset {
+		fields += lineNumber(int(m.ByteOffset), opts) + separator(sep, opts)
+	}
+	return fields + line + "\n"
+}
+
+// truncationMarker is printed after a line cut short by Options.MaxColumns.
+const truncationMarker = " [...]"
+
+// truncate cuts line to at most n bytes without splitting a UTF-8 sequence,
+// and clips the highlighted spans to what is left. It reports whether
+// anything was cut; n <= 0 means no limit.
+func truncate(line string, spans [][2]int, n int) (string, [][2]int, bool) {
+	if n <= 0 || len(line) <= n {
+		return line, spans, false
+	}
+	for n > 0 && !utf8.RuneStart(line[n]) {
+		n--
+	}
+
+	var kept [][2]int
+	for _, span := range spans {
+		if span[0] >= n {
+			break
+		}
+		kept = append(kept, [2]int{span[0], min(span[1], n)})
+	}
+	return line[:n], kept, true
+}
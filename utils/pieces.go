@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"runtime/debug"
+	"sync"
+)
+
+// minPieceSize is the least a mapped file is split into for searching its
+// pieces in parallel, so each goroutine has enough to be worth starting.
+const minPieceSize = 64 << 20
+
+// pieceCount is how many pieces a mapped file of size bytes is searched in,
+// up to one per worker. Options that stop early or need the lines around a
+// selected one are only supported by searching the file in one go.
+func pieceCount(size int, opts Options) int {
+	if opts.Before > 0 || opts.After > 0 || opts.MaxCount > 0 || opts.Preview > 0 || opts.Quiet || opts.FilesWithMatches || opts.FilesWithoutMatch {
+		return 1
+	}
+	return max(1, min(workerCount(opts), size/minPieceSize))
+}
+
+// splitLines returns the offsets at which data is cut into about n pieces of
+// equal size, each starting at the beginning of a line. The first is always
+// 0, and pieces are never empty.
+func splitLines(data []byte, n int) []int {
+	starts := []int{0}
+	for i := 1; i < n; i++ {
+		at := max(len(data)*i/n, starts[len(starts)-1])
+		end := bytes.IndexByte(data[at:], '\n')
+		if end == -1 || at+end+1 == len(data) {
+			break
+		}
+		if at+end+1 > starts[len(starts)-1] {
+			starts = append(starts, at+end+1)
+		}
+	}
+	return starts
+}
+
+// scanPieces searches the pieces of data beginning at starts in parallel and
+// puts their results together as if data had been searched in one go. The
+// lines of each piece are counted first, so they are numbered across the
+// whole file. data may be mapped, so every goroutine reading it turns a fault
+// into an error like scanMappedBytes.
+func scanPieces(ctx context.Context, data []byte, starts []int, name string, finder matcher, opts Options, isBinary bool) (fileResult, error) {
+	pieces := make([][]byte, len(starts))
+	for i, start := range starts {
+		end := len(data)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		pieces[i] = data[start:end]
+	}
+
+	var wg sync.WaitGroup
+	lineCounts := make([]int, len(pieces))
+	nuls := make([]bool, len(pieces))
+	faults := make([]bool, len(pieces))
+	for i, piece := range pieces {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+			defer func() { faults[i] = faulted(recover()) }()
+			lineCounts[i] = bytes.Count(piece, []byte("\n"))
+			nuls[i] = !opts.Text && bytes.IndexByte(piece, 0) != -1
+		}()
+	}
+	wg.Wait()
+	for _, fault := range faults {
+		if fault {
+			return fileResult{file: name}, shrunkError(name)
+		}
+	}
+
+	results := make([]fileResult, len(pieces))
+	errs := make([]error, len(pieces))
+	lineNumber := 1
+	for i, piece := range pieces {
+		wg.Add(1)
+		go func(lineNumber int, isBinary bool) {
+			defer wg.Done()
+			defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+			defer func() {
+				if faulted(recover()) {
+					results[i], errs[i] = fileResult{}, shrunkError(name)
+				}
+			}()
+			lines := &byteLines{data: piece, max: opts.MaxLineSize}
+			results[i], errs[i] = scanLinesOf(ctx, lines, name, finder, opts, isBinary, lineNumber, int64(starts[i]))
+		}(lineNumber, isBinary)
+		// a NUL in a piece makes the rest of the file binary, as it would
+		// be for a single scan
+		lineNumber += lineCounts[i]
+		isBinary = isBinary || nuls[i]
+	}
+	wg.Wait()
+
+	// a file is reported as binary at its first selected line once it is
+	// known to be binary, and nothing after that line is looked at
+	result := fileResult{file: name}
+	for i, r := range results {
+		result.count += r.count
+		result.size += r.size
+		result.matches = append(result.matches, r.matches...)
+		if errs[i] != nil {
+			return result, errs[i]
+		}
+		if n := len(r.matches); n > 0 && r.matches[n-1].Binary {
+			break
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// loadedFile is a file read into memory by a reader, waiting for a matcher.
+type loadedFile struct {
+	path string
+	data []byte
+}
+
+// reader reads the files from the walk into memory and hands them to the
+// matchers on loaded, with Options.IOThreads. A file too large to hold in
+// memory is searched by the reader itself, through a mapping.
+func reader(ctx context.Context, files <-chan string, finder matcher, opts Options, loaded chan<- loadedFile, results chan<- fileResult, problems *problems, open chan struct{}, progress *progress, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for {
+		var path string
+		select {
+		case <-ctx.Done():
+			return
+		case f, ok := <-files:
+			if !ok {
+				return
+			}
+			path = f
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			problems.report(fmt.Errorf("error in opening file: %w", err))
+			progress.searched()
+			continue
+		}
+		if info.Size() >= mmapThreshold {
+			if !searchFile(ctx, path, finder, opts, results, problems, open) {
+				return
+			}
+			progress.searched()
+			continue
+		}
+
+		data, ok := loadFile(ctx, path, info.Size(), problems, open)
+		if !ok {
+			progress.searched()
+			continue
+		}
+		select {
+		case loaded <- loadedFile{path: path, data: data}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// loadFile reads the whole of the file at path, expected to be about size
+// bytes, once there is a free slot in open. It returns false if the file
+// could not be read or the search was cancelled.
+func loadFile(ctx context.Context, path string, size int64, problems *problems, open chan struct{}) ([]byte, bool) {
+	select {
+	case open <- struct{}{}:
+		defer func() { <-open }()
+	case <-ctx.Done():
+		return nil, false
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		problems.report(fmt.Errorf("error in opening file: %w", err))
+		return nil, false
+	}
+	defer f.Close()
+
+	// the file may have grown since it was looked at, so it is read to
+	// the end rather than for size bytes
+	var buf bytes.Buffer
+	buf.Grow(int(size) + bytes.MinRead)
+	if _, err := buf.ReadFrom(f); err != nil {
+		problems.report(fmt.Errorf("error in reading file %s: %w", path, err))
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// matchWorker searches the files loaded by the readers until loaded is
+// closed.
+func matchWorker(ctx context.Context, loaded <-chan loadedFile, finder matcher, opts Options, results chan<- fileResult, problems *problems, progress *progress, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for {
+		var file loadedFile
+		select {
+		case <-ctx.Done():
+			return
+		case f, ok := <-loaded:
+			if !ok {
+				return
+			}
+			file = f
+		}
+
+		if !searchLoaded(ctx, file, finder, opts, results, problems) {
+			return
+		}
+		progress.searched()
+	}
+}
+
+// searchLoaded is searchFile for a file already read into memory.
+func searchLoaded(ctx context.Context, file loadedFile, finder matcher, opts Options, results chan<- fileResult, problems *problems) bool {
+	r := bytes.NewReader(file.data)
+	if opts.Decompress && isZip(file.path) {
+		return scanZip(ctx, r, r.Size(), file.path, finder, opts, results, problems)
+	}
+
+	if opts.Decompress {
+		decompressed, err := decompress(r)
+		if err != nil {
+			problems.report(fmt.Errorf("error in decompressing file %s: %w", file.path, err))
+			return true
+		}
+		if isTar(file.path) {
+			return scanTar(ctx, decompressed, file.path, finder, opts, results, problems)
+		}
+		if decompressed != io.Reader(r) {
+			result, err := scanReader(ctx, decompressed, file.path, finder, opts)
+			if err != nil {
+				problems.report(err)
+			}
+			return send(ctx, results, result)
+		}
+	}
+
+	result, err := scanBytes(ctx, file.data, file.path, finder, opts)
+	if err != nil {
+		problems.report(err)
+	}
+	return send(ctx, results, result)
+}
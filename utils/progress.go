@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is how often Options.Progress reports.
+const progressInterval = time.Second
+
+// progress counts the files searched so far and remembers the directory the
+// walk last entered, for Options.Progress. It is safe for concurrent use,
+// and a nil *progress keeps no count.
+type progress struct {
+	files atomic.Int64
+	dir   atomic.Pointer[string]
+}
+
+func newProgress() *progress {
+	return &progress{}
+}
+
+// searched counts one more file as searched.
+func (p *progress) searched() {
+	if p != nil {
+		p.files.Add(1)
+	}
+}
+
+// entered records that the walk has entered dir.
+func (p *progress) entered(dir string) {
+	p.dir.Store(&dir)
+}
+
+// report writes the progress so far to problems every progressInterval,
+// until stop is closed.
+func (p *progress) report(problems *problems, stop <-chan struct{}) {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		msg := fmt.Sprintf("%d files searched", p.files.Load())
+		if dir := p.dir.Load(); dir != nil {
+			msg += ", now in " + *dir
+		}
+		problems.note(msg)
+	}
+}
@@ -0,0 +1,207 @@
+package utils
+
+import (
+	"regexp"
+	"unicode/utf8"
+)
+
+// replaced is a match to be replaced, with the regex that made it and the
+// offsets of its capture groups for expanding "$1", if it was made by one.
+type replaced struct {
+	span [2]int
+	re   *regexp.Regexp
+	loc  []int
+}
+
+// replaceLine returns text with every match of m replaced by template, and
+// the byte ranges the replacements take up in it. Matches made by a regex
+// expand their capture groups in template like regexp.Expand.
+func replaceLine(m matcher, text []byte, template string) (string, [][2]int) {
+	var out []byte
+	var spans [][2]int
+	last := 0
+	for _, r := range replacements(m, text) {
+		// an overlapping match has already been replaced with the one
+		// before it
+		if r.span[0] < last {
+			continue
+		}
+		out = append(out, text[last:r.span[0]]...)
+		start := len(out)
+		out = r.expand(out, text, template)
+		spans = append(spans, [2]int{start, len(out)})
+		last = r.span[1]
+	}
+	out = append(out, text[last:]...)
+	return string(out), spans
+}
+
+// expand appends what r is replaced by to dst.
+func (r replaced) expand(dst []byte, text []byte, template string) []byte {
+	if r.re == nil {
+		return append(dst, template...)
+	}
+	return r.re.Expand(dst, []byte(template), text, r.loc)
+}
+
+// replacements returns every match of m in text like findAll, along with
+// the regex that made each, if any.
+func replacements(m matcher, text []byte) []replaced {
+	switch c := m.(type) {
+	case allMatcher:
+		return replacements(c.any, text)
+	case notMatcher:
+		return replacements(c.m, text)
+	case regexMatcher:
+		var rs []replaced
+		for _, loc := range c.re.FindAllSubmatchIndex(text, -1) {
+			rs = append(rs, replaced{span: [2]int{loc[0], loc[1]}, re: c.re, loc: loc})
+		}
+		return rs
+	}
+
+	// matches made by a regex are found again with their groups, the
+	// same way findAll finds them, rather than by running the regex on
+	// the match alone, where a lazy group or -w could match differently
+	var rs []replaced
+	if len(regexesOf(m)) == 0 {
+		for _, span := range findAll(m, text) {
+			rs = append(rs, replaced{span: span})
+		}
+		return rs
+	}
+	for offset := 0; offset <= len(text); {
+		re, loc := submatchFrom(m, text, offset)
+		if loc == nil {
+			break
+		}
+		rs = append(rs, replaced{span: [2]int{loc[0], loc[1]}, re: re, loc: loc})
+		offset = loc[1]
+		if loc[1] == loc[0] {
+			offset++
+		}
+	}
+	return rs
+}
+
+// submatcher is a matcher whose matches can come with the capture groups of
+// the regex that made them.
+type submatcher interface {
+	// submatchFrom is findFrom, also returning the regex that made the
+	// match and the offsets in text of the match and its capture groups,
+	// like regexp.FindSubmatchIndex. It returns nil offsets if there is no
+	// match, and a nil regex for a match made by a literal.
+	submatchFrom(text []byte, from int) (*regexp.Regexp, []int)
+}
+
+// submatchFrom returns the first match of m in text starting at from or
+// later, with its capture groups if m is a submatcher.
+func submatchFrom(m matcher, text []byte, from int) (*regexp.Regexp, []int) {
+	if s, ok := m.(submatcher); ok {
+		return s.submatchFrom(text, from)
+	}
+	start, end := findFrom(m, text, from)
+	if start == -1 {
+		return nil, nil
+	}
+	return nil, []int{start, end}
+}
+
+func (m regexMatcher) submatchFrom(text []byte, from int) (*regexp.Regexp, []int) {
+	loc := m.re.FindSubmatchIndex(text[from:])
+	if loc == nil {
+		return nil, nil
+	}
+	return m.re, shiftLoc(loc, from)
+}
+
+// submatchFrom picks the match multiMatcher.findFrom would.
+func (ms multiMatcher) submatchFrom(text []byte, from int) (*regexp.Regexp, []int) {
+	var re *regexp.Regexp
+	var loc []int
+	for _, m := range ms {
+		r, l := submatchFrom(m, text, from)
+		if l == nil {
+			continue
+		}
+		if loc == nil || l[0] < loc[0] || l[0] == loc[0] && l[1] > loc[1] {
+			re, loc = r, l
+		}
+		if loc[0] == from && loc[1] == len(text) {
+			break
+		}
+	}
+	return re, loc
+}
+
+// shiftLoc adds offset to every offset in loc that is not -1, for groups
+// that did not take part in the match.
+func shiftLoc(loc []int, offset int) []int {
+	for i := range loc {
+		if loc[i] != -1 {
+			loc[i] += offset
+		}
+	}
+	return loc
+}
+
+// appendEachReplaced is appendEachMatch for Options.Replace, with the Line
+// of each Match replaced.
+func appendEachReplaced(dst []Match, name string, lineNumber int, offset int64, text []byte, finder matcher, opts Options) []Match {
+	line, lineSpans := replaceLine(finder, text, *opts.Replace)
+	last, replacedSpans := 0, 0
+	for _, r := range replacements(finder, text) {
+		// like replaceLine, a match overlapping the one before is not
+		// replaced in the line, but is still printed on its own
+		var span [2]int
+		if r.span[0] >= last {
+			span = lineSpans[replacedSpans]
+			replacedSpans++
+			last = r.span[1]
+		} else if !opts.OnlyMatching {
+			continue
+		}
+		if r.span[0] == r.span[1] {
+			continue
+		}
+		m := Match{File: name, LineNumber: lineNumber, ByteOffset: offset, Line: line, Column: r.span[0] + 1}
+		if opts.RuneColumn {
+			m.Column = utf8.RuneCount(text[:r.span[0]]) + 1
+		}
+		if opts.OnlyMatching {
+			m.Line = string(r.expand(nil, text, *opts.Replace))
+			m.ByteOffset += int64(r.span[0])
+			span = [2]int{0, len(m.Line)}
+		}
+		if wantSpans(opts) {
+			m.spans = [][2]int{span}
+		}
+		dst = append(dst, m)
+	}
+	return dst
+}
+
+// regexesOf returns the regexes m is made of.
+func regexesOf(m matcher) []*regexp.Regexp {
+	switch c := m.(type) {
+	case regexMatcher:
+		return []*regexp.Regexp{c.re}
+	case allMatcher:
+		return regexesOf(c.any)
+	case notMatcher:
+		return regexesOf(c.m)
+	case wordMatcher:
+		return regexesOf(c.m)
+	case wordRegexMatcher:
+		return []*regexp.Regexp{c.re}
+	case lineMatcher:
+		return regexesOf(c.m)
+	case multiMatcher:
+		var res []*regexp.Regexp
+		for _, sub := range c {
+			res = append(res, regexesOf(sub)...)
+		}
+		return res
+	}
+	return nil
+}
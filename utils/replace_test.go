@@ -0,0 +1,29 @@
+package utils
+
+import "testing"
+
+func TestReplaceGroups(t *testing.T) {
+	template := "<$1>"
+	tests := []struct {
+		name     string
+		content  string
+		patterns []string
+		opts     Options
+		want     string
+	}{
+		{"word lazy group", "x aaa y\n", []string{"(a+?)"}, Options{WordRegexp: true}, "1:x <aaa> y\n"},
+		{"word lazy group only matching", "x aaa y\n", []string{"(a+?)"}, Options{WordRegexp: true, OnlyMatching: true}, "1:<aaa>\n"},
+		{"word groups of several", "ab cd\n", []string{"(a)b", "(c)d"}, Options{WordRegexp: true}, "1:<a> <c>\n"},
+		{"not a boundary", "xaa\n", []string{`\B(a+)`, "zzz"}, Options{}, "1:x<aa>\n"},
+		{"multiline word lazy group", "x aaa\ny\n", []string{"(a+?)"}, Options{WordRegexp: true, Multiline: true, OnlyMatching: true}, "1:<aaa>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.Regex, opts.Replace = true, &template
+			if got := grepFile(t, tt.content, opts, tt.patterns...); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package utils
+
+// lineRing holds the most recent lines that were not printed, up to a fixed
+// number, so they can be emitted as leading context once a match turns up.
+type lineRing struct {
+	lines []Match
+	start int
+	n     int
+}
+
+func newLineRing(size int) *lineRing {
+	return &lineRing{lines: make([]Match, size)}
+}
+
+// push records a line and its ending, evicting the oldest one if the ring is
+// full. text is copied, so the scanner's buffer can be passed directly.
+func (r *lineRing) push(file string, lineNumber int, offset int64, text []byte, ending string) {
+	size := len(r.lines)
+	if size == 0 {
+		return
+	}
+
+	line := Match{File: file, LineNumber: lineNumber, ByteOffset: offset, Line: string(text), Context: true, ending: ending}
+	if r.n < size {
+		r.lines[(r.start+r.n)%size] = line
+		r.n++
+		return
+	}
+	r.lines[r.start] = line
+	r.start = (r.start + 1) % size
+}
+
+// drain appends the held lines to dst, oldest first, and empties the ring.
+func (r *lineRing) drain(dst []Match) []Match {
+	for i := 0; i < r.n; i++ {
+		dst = append(dst, r.lines[(r.start+i)%len(r.lines)])
+	}
+	r.start, r.n = 0, 0
+	return dst
+}
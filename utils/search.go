@@ -3,71 +3,953 @@ package utils
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
-	"strings"
+	"regexp"
+	"runtime"
 	"sync"
+	"time"
+	"unicode/utf8"
 )
 
-func ConcurrentGrep (pattern string, directory string, threads int) {
-	files := make(chan string)
-	results := make(chan string)
+// DefaultMaxLineSize is the longest line a worker will read when
+// Options.MaxLineSize is not set.
+const DefaultMaxLineSize = 4 << 20
 
-	var wg sync.WaitGroup
-	numWorkers := threads
-	for i := 0; i < numWorkers; i++ {
-		wg.Add(1)
-		go worker(files, []byte(pattern), results, &wg)
+// DefaultMaxOpenFiles is how many files and directories a search keeps open
+// at once when Options.MaxOpenFiles is not set, well below the usual limit
+// of 1024 descriptors per process.
+const DefaultMaxOpenFiles = 256
+
+// stdinName is how matches read from standard input are reported.
+const stdinName = "(standard input)"
+
+// binaryPeekSize is how much of the start of a file is checked for a NUL
+// byte to decide if it is binary, the same heuristic grep uses.
+const binaryPeekSize = 8 << 10
+
+// Options controls how SearchRoots and ConcurrentGrep search. SearchRoots
+// takes them through WithOptions or the other Option functions.
+type Options struct {
+	// Threads is the number of workers scanning files concurrently. Zero or
+	// less uses ThreadsPerCPU workers for every CPU.
+	Threads int
+
+	// IOThreads, if set, splits the work in two: this many readers open
+	// files and read them into memory, while the Threads workers only
+	// search what has been read. Files of 32 MiB and more are searched by
+	// the readers. It is ignored with WalkOrder.
+	IOThreads int
+
+	// ThreadsPerCPU scales the worker count when Threads is not set. Slow
+	// network filesystems keep workers waiting on I/O, where a small
+	// multiple of the CPUs does better. It defaults to 1.
+	ThreadsPerCPU int
+
+	// Regex treats the pattern as a regular expression instead of a literal
+	// string. Literal search uses Boyer-Moore and is considerably faster.
+	Regex bool
+
+	// IgnoreCase matches the pattern without regard to ASCII letter case.
+	IgnoreCase bool
+
+	// UnicodeCase matches without regard to case across all of Unicode
+	// rather than only ASCII letters, folding the pattern and every line
+	// so that "Ä" matches "ä" and "ß" matches "SS". It is slower than
+	// IgnoreCase, which it implies unless SmartCase is set, in which case
+	// it folds only the patterns SmartCase does. Regexes always fold
+	// Unicode letters, one character for another.
+	UnicodeCase bool
+
+	// SmartCase matches a pattern without regard to case if it has no upper
+	// case letters, and with regard to case otherwise, like ripgrep. Each
+	// pattern is judged on its own.
+	SmartCase bool
+
+	// AllPatterns only selects lines matched by every one of the patterns,
+	// instead of any of them.
+	AllPatterns bool
+
+	// NotPatterns never selects lines matching any of these patterns, even
+	// if they match those searched for. They are matched the same way.
+	NotPatterns []string
+
+	// WordRegexp only matches the pattern as a whole word, with no word
+	// character ([A-Za-z0-9_]) directly before or after it.
+	WordRegexp bool
+
+	// Multiline matches the patterns against the whole contents of each
+	// file rather than line by line, so a match can span lines. Regexes
+	// are compiled with the s and m flags: "." matches a newline, and "^"
+	// and "$" the start and end of each line. Every line of a match is
+	// reported. Files are read into memory whole, Invert has no effect
+	// and no context lines are reported.
+	Multiline bool
+
+	// LineRegexp only matches the pattern against the whole line. It takes
+	// precedence over WordRegexp.
+	LineRegexp bool
+
+	// LineStart and LineEnd only match the pattern at the start or at the
+	// end of the line, like "^" and "$" in a regex but without needing
+	// Regex. Both together are the same as LineRegexp.
+	LineStart bool
+	LineEnd   bool
+
+	// Invert selects the lines that do not match the pattern.
+	Invert bool
+
+	// Count makes ConcurrentGrep print the number of selected lines per file
+	// instead of the lines themselves. SearchRoots ignores it.
+	Count bool
+
+	// TotalCount makes ConcurrentGrep print only the number of selected
+	// lines across all files, once the search is done. SearchRoots ignores
+	// it.
+	TotalCount bool
+
+	// IncludeZero also reports files with no selected lines in Count mode.
+	IncludeZero bool
+
+	// MaxCount stops reading a file after this many selected lines, apart
+	// from their trailing context. With Multiline a match spanning lines
+	// may be cut short by it. Zero means no limit.
+	MaxCount int
+
+	// MaxTotal stops the whole search once ConcurrentGrep has this many
+	// selected lines across all files, which it prints along with their
+	// trailing context. Exactly that many are printed, never more: the
+	// files still being searched when the limit is reached, at most one
+	// per worker, are abandoned, and what they and any other finished file
+	// not yet printed found is dropped. Which lines make up the total
+	// depends on the order files finish in, unless SortFiles or WalkOrder
+	// is set. Zero means no limit. SearchRoots ignores it.
+	MaxTotal int
+
+	// Preview, if set, reports at most this many selected lines of each
+	// file, like MaxCount, but goes on reading to count the rest, which
+	// ConcurrentGrep prints as "(+12 more)" below them. It has no effect
+	// with Multiline.
+	Preview int
+
+	// Quiet makes ConcurrentGrep print nothing and stop the whole search as
+	// soon as a line is selected. SearchRoots ignores it.
+	Quiet bool
+
+	// FilesWithMatches makes ConcurrentGrep print only the names of files
+	// with a selected line. Scanning a file stops at its first selected line.
+	// SearchRoots ignores it.
+	FilesWithMatches bool
+
+	// FilesWithoutMatch is the opposite of FilesWithMatches, printing only
+	// the names of files without any selected line. SearchRoots ignores it.
+	FilesWithoutMatch bool
+
+	// NameOnly matches the patterns against the path of each file below
+	// its root instead of against its contents, which are never read, like
+	// find. ConcurrentGrep prints the paths selected like FilesWithMatches,
+	// and SearchRoots returns a Match for each with only File set. Standard
+	// input has no path and is skipped.
+	NameOnly bool
+
+	// Column makes ConcurrentGrep print the column of the first match on each
+	// selected line after its line number.
+	Column bool
+
+	// JSON makes ConcurrentGrep print each Match as a JSON object on its own
+	// line instead of as text.
+	JSON bool
+
+	// JSONEvents makes ConcurrentGrep print the stream of JSON messages
+	// ripgrep prints with --json instead of text: "begin" and "end" around
+	// the "match" and "context" lines of each file with a selected line,
+	// and a "summary" at the end, so tools reading ripgrep can read zgrep.
+	// OnlyMatching, Vimgrep, counting and listing files don't apply to it.
+	JSONEvents bool
+
+	// Color highlights matches, file names, line numbers and separators in the
+	// output of ConcurrentGrep with ANSI escape sequences using Colors.
+	Color  bool
+	Colors Colors
+
+	// Null terminates file names with a NUL byte instead of a newline when
+	// only names are printed, and separates them from the rest of the line
+	// with one otherwise, so any file name can be parsed back.
+	Null bool
+
+	// ByteOffset prints Match.ByteOffset after the line and column numbers.
+	ByteOffset bool
+
+	// RuneColumn counts Match.Column in UTF-8 characters rather than bytes.
+	RuneColumn bool
+
+	// OnlyMatching reports each match on a selected line as a Match of its
+	// own, whose Line is just the matched text. Context lines are not
+	// reported, and nothing is with Invert.
+	OnlyMatching bool
+
+	// Overlapping finds every occurrence of a literal pattern on a line,
+	// including those that start inside the one before, so OnlyMatching
+	// reports "aa" three times in "aaaa" rather than twice, and JSONEvents
+	// counts three matches. Without it matches never overlap, like
+	// bytes.Count and CountMatches. Count still counts selected lines,
+	// which are the same either way. Regexes, and several patterns
+	// searched for together, are never matched overlapping.
+	Overlapping bool
+
+	// PreFilter looks for a literal pattern by jumping from one occurrence
+	// of its first byte to the next with bytes.IndexByte, comparing the
+	// rest only there. It is much faster on long lines where that byte is
+	// rare, and slower where it is common. It has no effect on patterns
+	// matched without regard to case whose first byte is a letter, nor
+	// on many patterns searched for together.
+	PreFilter bool
+
+	// Heading groups the lines printed by ConcurrentGrep by file, with the
+	// file name printed once above them instead of at the start of each
+	// line, like ripgrep does in a terminal. Files are separated by a blank
+	// line.
+	Heading bool
+
+	// Vimgrep reports each match on a selected line as a Match of its own
+	// with the whole line, printed as "file:line:column:text" for editors
+	// to jump to. Context lines are not reported.
+	Vimgrep bool
+
+	// NoFilename leaves the file name out of lines and counts printed by
+	// ConcurrentGrep, as when only one file is searched. Listed files and
+	// JSON output are unaffected.
+	NoFilename bool
+
+	// Encoding, if set, is the character set every file is in, by a label
+	// such as "latin1", "shift_jis" or "utf-16le" from the WHATWG Encoding
+	// Standard. Files are decoded to UTF-8 before they are searched, and
+	// lines, offsets and columns are those of the decoded text. Otherwise
+	// only UTF-16 with a byte order mark is recognised.
+	Encoding string
+
+	// Text searches binary files as if they were text, reporting their
+	// lines like any other instead of just saying that the file matches.
+	Text bool
+
+	// FieldSeparator is printed between the file name, the numbers and the
+	// text of a selected line, and between a file name and its count. It
+	// defaults to ":". Context lines always use "-".
+	FieldSeparator string
+
+	// GroupSeparator is printed on a line of its own between groups of
+	// lines that don't follow on from each other with Before or After. It
+	// defaults to "--".
+	GroupSeparator string
+
+	// MaxColumns, if set, cuts lines printed by ConcurrentGrep after this
+	// many bytes and marks them with "[...]". Only the output is affected,
+	// lines are still matched and reported as a whole.
+	MaxColumns int
+
+	// Replace, if set, reports selected lines with every match replaced by
+	// it, or with OnlyMatching just the replacement, to preview an edit.
+	// Files are never changed. In regex mode "$1" or "${name}" stands for
+	// what that group of the match captured. Context lines are reported as
+	// they are, and with Multiline only OnlyMatching replaces anything.
+	Replace *string
+
+	// Before and After are the number of context lines reported before and
+	// after each selected line. Overlapping context is only reported once.
+	Before int
+	After  int
+
+	// Include restricts the search to files whose base name matches at least
+	// one of these globs, as understood by filepath.Match.
+	Include []string
+
+	// Exclude skips files whose base name matches any of these globs, even if
+	// they also match Include.
+	Exclude []string
+
+	// Path, if set, restricts the search to files whose path below the
+	// root, with "/" between its parts, it matches, and PathNot skips
+	// files whose path it matches, such as `\.min\.js$`. Like Include and
+	// Exclude they don't apply to files named as roots.
+	Path    *regexp.Regexp
+	PathNot *regexp.Regexp
+
+	// Types restricts the search to files of at least one of these types,
+	// such as "go" or "py", and TypesNot skips files of any of them. Both
+	// look the names up in FileTypes, or in the built in types if it is
+	// nil, see DefaultFileTypes.
+	Types     []string
+	TypesNot  []string
+	FileTypes FileTypes
+
+	// MaxLineSize is the length in bytes of the longest line that can be
+	// scanned. Files with longer lines are abandoned with an error at that
+	// line. It defaults to DefaultMaxLineSize.
+	MaxLineSize int
+
+	// Hidden searches files and directories whose name starts with a dot,
+	// which are skipped by default. Ignore files still apply to them.
+	Hidden bool
+
+	// Follow descends into symbolically linked directories. Each directory is
+	// only searched once, however many links lead to it, so link cycles
+	// don't recurse forever. Linked files are always searched.
+	Follow bool
+
+	// MaxDepth, if set, limits how many directories deep below the root the
+	// walk goes. A depth of 0 only searches files directly in the root.
+	MaxDepth *int
+
+	// MaxFileSize skips files larger than this many bytes. Zero means no
+	// limit.
+	MaxFileSize int64
+
+	// NewerThan and OlderThan, if set, skip files last modified before or
+	// after them. ParseTime turns flags such as "24h" into these times.
+	NewerThan time.Time
+	OlderThan time.Time
+
+	// Dedup searches each file only once, however many links, hard links or
+	// roots lead to it. Files are told apart by device and inode number, or
+	// by their absolute path with links resolved where there are none.
+	Dedup bool
+
+	// Gitignore skips files and directories matched by .gitignore files in
+	// the searched directory and its subdirectories, and by .ignore files,
+	// which work the same way outside git too. Where a directory has both,
+	// the rules of .ignore take precedence.
+	Gitignore bool
+
+	// IgnoreFiles are more files of gitignore patterns, applied below every
+	// root as if they were a .gitignore in it. They are used even without
+	// Gitignore, and ignore files found in the tree take precedence.
+	IgnoreFiles []string
+
+	// Decompress searches the contents of gzip compressed files rather than
+	// their compressed bytes, and the members of zip and tar (optionally
+	// gzipped) archives, which are reported as "archive.zip/member". Line
+	// numbers count decompressed lines.
+	Decompress bool
+
+	// MaxOpenFiles limits how many files and directories the workers and the
+	// walk have open at once, however many workers there are. It defaults to
+	// DefaultMaxOpenFiles.
+	MaxOpenFiles int
+
+	// Stdin is searched instead of a directory when the directory is "-". It
+	// defaults to os.Stdin.
+	Stdin io.Reader
+
+	// SortFiles reports files in the order of SortBy instead of as soon as
+	// they have been searched. Nothing is reported until the whole search
+	// is done.
+	SortFiles bool
+	SortBy    SortKey
+
+	// WalkOrder reports files in the order the walk finds them, names in
+	// each directory sorted and subdirectories searched where they come,
+	// which is the same on every run. Results are held back only until
+	// those of the files before them are reported. SortFiles takes
+	// precedence.
+	WalkOrder bool
+
+	// Progress makes ConcurrentGrep write how many files have been
+	// searched so far, and the directory the walk is in, to ErrOutput
+	// every second, so a long search without matches can be told from one
+	// that is stuck. SearchRoots ignores it.
+	Progress bool
+
+	// Stats makes ConcurrentGrep finish with a summary of how many files
+	// were searched, how many of them and how many lines were selected, and
+	// how long it took.
+	Stats bool
+
+	// Output is where ConcurrentGrep prints results. It defaults to os.Stdout.
+	Output io.Writer
+
+	// ErrOutput receives diagnostics about files that could not be walked or
+	// read as they happen. It defaults to os.Stderr. The same errors are
+	// returned together as a *SearchError at the end of the search.
+	ErrOutput io.Writer
+}
+
+// Match is a line selected by a search. The JSON field names are those
+// printed by ConcurrentGrep with Options.JSON.
+type Match struct {
+	File       string `json:"path"`
+	LineNumber int    `json:"line_number"`
+	Line       string `json:"line"`
+
+	// ByteOffset is where Line starts in the file, counting every line
+	// before it with its "\n" or "\r\n" ending. With Options.OnlyMatching it
+	// is where the match starts. In UTF-16 files it counts the bytes of the
+	// text decoded to UTF-8.
+	ByteOffset int64 `json:"byte_offset"`
+
+	// Column is the 1-based position of the first match in Line, in bytes or
+	// in characters with Options.RuneColumn. It is 0 when the line was
+	// selected without matching, as with Options.Invert.
+	Column int `json:"column,omitempty"`
+
+	// Binary is set when File is a binary file containing a selected line.
+	// Only the first such line is reported, Line is left empty and
+	// ByteOffset is where the match starts.
+	Binary bool `json:"binary,omitempty"`
+
+	// Context is set for lines reported only because they are near a
+	// selected line, see Options.Before and Options.After.
+	Context bool `json:"context,omitempty"`
+
+	// ending is how Line ended in the input, "\n", "\r\n", or nothing for a
+	// last line without a newline. The JSON events print it after the line.
+	ending string
+
+	// spans are the byte ranges of every match in Line, only collected when
+	// wantSpans says they are needed.
+	spans [][2]int
+}
+
+// wantSpans reports whether the output opts asks for needs Match.spans, to
+// colour the matches or list them as JSON events.
+func wantSpans(opts Options) bool {
+	return opts.Color || opts.JSONEvents
+}
+
+// fileResult is everything a worker found in a single file.
+type fileResult struct {
+	file    string
+	matches []Match
+	count   int
+
+	// hidden is how many of the count selected lines were left out of
+	// matches by Options.Preview.
+	hidden int
+
+	// size is how many bytes of the file were read.
+	size int64
+}
+
+// SearchContext returns every line under directory selected by any of
+// patterns. A directory of "-" searches opts.Stdin instead. It stops early
+// when ctx is cancelled, in which case it returns the matches found so far
+// along with ctx.Err(). Otherwise, if some paths could not be searched, the
+// matches from the rest are returned with a *SearchError.
+//
+// Deprecated: use SearchRoots with WithContext, WithPatterns and WithOptions.
+func SearchContext(ctx context.Context, patterns []string, directory string, opts Options) ([]Match, error) {
+	return searchMatches(ctx, patterns, []string{directory}, opts)
+}
+
+// Search returns every line under directory selected by any of patterns. A
+// directory of "-" searches opts.Stdin instead. If some paths could not be
+// searched, the matches from the rest are returned with a *SearchError. What
+// was first Search(pattern, directory, threads) is now
+// Search([]string{pattern}, directory, Options{Threads: threads}).
+//
+// Deprecated: use SearchRoots with WithPatterns and WithOptions.
+func Search(patterns []string, directory string, opts Options) ([]Match, error) {
+	return searchMatches(context.Background(), patterns, []string{directory}, opts)
+}
+
+// searchMatches collects every line under roots selected by any of patterns,
+// for SearchContext, Search and SearchRoots.
+func searchMatches(ctx context.Context, patterns []string, roots []string, opts Options) ([]Match, error) {
+	s, err := NewSearcher(patterns, opts)
+	if err != nil {
+		return nil, err
 	}
+	return s.matches(ctx, roots)
+}
 
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
+// GrepReader returns every line read from r selected by any of patterns,
+// reported under name. It lets anything that can be read, such as a network
+// stream or an in-memory buffer, be searched like a file. If reading fails
+// part way, the matches so far are returned along with the error.
+func GrepReader(r io.Reader, name string, patterns []string, opts Options) ([]Match, error) {
+	if len(patterns) == 0 {
+		return nil, errors.New("no pattern given")
+	}
+	res, err := compilePatterns(patterns, opts)
+	if err != nil {
+		return nil, err
+	}
 
-	go func() {
-		// write a simple directory walk to eliminate the extra syscalls 
-		err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
+	if err := checkEncoding(opts.Encoding); err != nil {
+		return nil, err
+	}
+	opts = scanDefaults(everyLine(opts))
 
-			relPath, err := filepath.Rel(directory, path)
-			if err != nil {
-				return err
-			}
-			components := strings.Split(relPath, string(filepath.Separator))
+	if opts.Decompress {
+		if r, err = decompress(r); err != nil {
+			return nil, fmt.Errorf("error in decompressing %s: %w", name, err)
+		}
+	}
+	result, err := scanReader(context.Background(), r, name, newMatcher(patterns, res, opts), opts)
+	return result.matches, err
+}
 
-			for _, c := range components {
-				if strings.HasPrefix(c, ".") {
-					if info.IsDir() {
-						// skip the entire directory
-						continue
-					} 
-					return nil
-				}
+// everyLine turns off the settings that only change how ConcurrentGrep
+// prints, such as counting or listing files, for the searches that return
+// every selected line.
+func everyLine(opts Options) Options {
+	opts.Count = false
+	opts.TotalCount = false
+	opts.FilesWithMatches = false
+	opts.FilesWithoutMatch = false
+	opts.Quiet = false
+	opts.Progress = false
+	return opts
+}
+
+// scanDefaults fills in the settings scanReader relies on that opts leaves
+// unset.
+func scanDefaults(opts Options) Options {
+	if opts.MaxLineSize <= 0 {
+		opts.MaxLineSize = DefaultMaxLineSize
+	}
+	if opts.MaxOpenFiles <= 0 {
+		opts.MaxOpenFiles = DefaultMaxOpenFiles
+	}
+	// the total only needs each file's count, not its lines
+	if opts.TotalCount {
+		opts.Count = true
+	}
+	// a match on its own has no surrounding lines to report, and a match
+	// over several lines is not looked at line by line
+	if opts.OnlyMatching || opts.Vimgrep || opts.Multiline {
+		opts.Before, opts.After = 0, 0
+	}
+	return opts
+}
+
+// compilePatterns compiles every pattern as a regular expression when opts
+// asks for regexes, and returns nil otherwise.
+func compilePatterns(patterns []string, opts Options) ([]*regexp.Regexp, error) {
+	// the patterns excluding lines are compiled again by each matcher,
+	// they are only checked here
+	if _, err := compileRegexes(opts.NotPatterns, opts); err != nil {
+		return nil, err
+	}
+	return compileRegexes(patterns, opts)
+}
+
+// compileRegexes compiles each of patterns as compilePatterns does.
+func compileRegexes(patterns []string, opts Options) ([]*regexp.Regexp, error) {
+	if !opts.Regex {
+		return nil, nil
+	}
+	var res []*regexp.Regexp
+	for _, pattern := range patterns {
+		expr := pattern
+		if opts.LineRegexp {
+			expr = "^(?:" + expr + ")$"
+		} else if opts.LineStart || opts.LineEnd {
+			if opts.LineStart {
+				expr = "^(?:" + expr + ")"
 			}
-			
-			if !info.IsDir() {
-				files <- path
+			if opts.LineEnd {
+				expr = "(?:" + expr + ")$"
 			}
-			return nil
-		})
+		}
+		if opts.Multiline {
+			// "." crosses lines, while "^" and "$" still match at each
+			expr = "(?sm)" + expr
+		}
+		if foldCase(pattern, opts) {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
 		if err != nil {
-			fmt.Printf("error in walking directory: %s\n", err)
+			return nil, fmt.Errorf("invalid regular expression: %w", err)
 		}
-		close(files)
-	}()
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// ConcurrentGrep searches roots, directories or files, for lines matching any
+// of patterns and prints the results to opts.Output as they are found. A root
+// of "-" searches opts.Stdin. It reports whether
+// any line was selected, or with FilesWithoutMatch whether any file was
+// listed. Like SearchContext, it returns a *SearchError if some paths could
+// not be searched.
+func ConcurrentGrep (patterns []string, roots []string, opts Options) (bool, error) {
+	return ConcurrentGrepContext(context.Background(), patterns, roots, opts)
+}
 
+// ConcurrentGrepContext is ConcurrentGrep, stopping early when ctx is
+// cancelled. What was found by then is still printed, followed by the
+// summary of what was searched, and ctx.Err() is returned. The summary is
+// written to Options.ErrOutput unless Options.Stats puts it in the output.
+func ConcurrentGrepContext(ctx context.Context, patterns []string, roots []string, opts Options) (bool, error) {
+	// quiet mode stops everything at the first selected line
+	parent := ctx
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	stats := searchStats{start: time.Now()}
+	results, problems, err := search(ctx, patterns, roots, opts)
+	if err != nil {
+		return false, err
+	}
+
+	output := opts.Output
+	if output == nil {
+		output = os.Stdout
+	}
+	p := newPrinter(output, opts)
+	matched := false
+
+	left := opts.MaxTotal
 	for result := range results {
-		fmt.Println(result)
+		if opts.MaxTotal > 0 {
+			// what is still in flight once the limit is reached is
+			// drained without being printed while the workers wind down
+			if left == 0 {
+				continue
+			}
+			result, left = limitResult(result, left, opts)
+			if left == 0 {
+				cancel()
+			}
+		}
+		stats.add(result)
+		if opts.FilesWithoutMatch {
+			matched = matched || result.count == 0
+		} else {
+			matched = matched || result.count > 0
+		}
+
+		// keep draining after cancelling, so the workers can wind down
+		if opts.Quiet {
+			if matched {
+				cancel()
+			}
+			continue
+		}
+
+		if err := p.print(result); err != nil {
+			return matched, err
+		}
+		// output is written in batches, but never held back while the
+		// search is waiting on the workers
+		if len(results) == 0 {
+			if err := p.out.Flush(); err != nil {
+				return matched, err
+			}
+		}
+	}
+	if opts.TotalCount && !opts.Quiet {
+		fmt.Fprintln(p.out, stats.lines)
+	}
+	if opts.JSONEvents && !opts.Quiet {
+		if err := p.printSummary(time.Since(stats.start)); err != nil {
+			return matched, err
+		}
+	} else if opts.Stats && !opts.Quiet {
+		fmt.Fprint(p.out, stats.format(time.Since(stats.start)))
+	}
+	if err := p.out.Flush(); err != nil {
+		return matched, err
+	}
+	if err := parent.Err(); err != nil {
+		// an interrupted search says how far it got even without Stats,
+		// on ErrOutput so it stays apart from the lines printed
+		if !opts.Stats && !opts.JSONEvents && !opts.Quiet {
+			errOutput := opts.ErrOutput
+			if errOutput == nil {
+				errOutput = os.Stderr
+			}
+			fmt.Fprint(errOutput, stats.format(time.Since(stats.start)))
+		}
+		return matched, err
+	}
+	return matched, problems.err()
+}
+
+// limitResult cuts result down to at most left selected lines for
+// Options.MaxTotal, keeping the trailing context of the last, and returns how
+// many are left after it.
+func limitResult(result fileResult, left int, opts Options) (fileResult, int) {
+	if result.count <= left {
+		return result, left - result.count
+	}
+
+	// only matches on lines of their own count, as OnlyMatching reports
+	// several matches of one line
+	kept, lastLine := 0, -1
+	for i, m := range result.matches {
+		if m.Context || m.LineNumber == lastLine {
+			continue
+		}
+		if kept == left {
+			end := i
+			for end > 0 && result.matches[end-1].Context {
+				end--
+			}
+			for after := 0; end < len(result.matches) && result.matches[end].Context && after < opts.After; after++ {
+				end++
+			}
+			result.matches = result.matches[:end]
+			break
+		}
+		kept++
+		lastLine = m.LineNumber
+	}
+	result.count = left
+	result.hidden = 0
+	return result, 0
+}
+
+// ListFiles prints every file under roots that a search would read to
+// opts.Output, without opening any of them, so the filters in opts can be
+// checked. Names are printed like with FilesWithMatches. It returns a
+// *SearchError if some paths could not be walked.
+func ListFiles(roots []string, opts Options) error {
+	if err := checkGlobs(opts.Include); err != nil {
+		return err
+	}
+	if err := checkGlobs(opts.Exclude); err != nil {
+		return err
+	}
+	if err := checkTypes(opts); err != nil {
+		return err
+	}
+	ignoreRules, err := readIgnoreFiles(opts.IgnoreFiles)
+	if err != nil {
+		return err
+	}
+	problems := newProblems(opts)
+	opts = scanDefaults(opts)
+
+	// standard input is not a file to list
+	var paths []string
+	for _, root := range roots {
+		if root != "-" {
+			paths = append(paths, root)
+		}
+	}
+	files := make(chan string, 4*workerCount(opts))
+	open := make(chan struct{}, opts.MaxOpenFiles)
+	go walkRoots(context.Background(), paths, opts, ignoreRules, files, problems, open, nil, nil)
+
+	output := opts.Output
+	if output == nil {
+		output = os.Stdout
+	}
+	out := bufio.NewWriter(output)
+	var names []string
+	for file := range files {
+		if opts.SortFiles {
+			names = append(names, file)
+			continue
+		}
+		fmt.Fprint(out, formatName(file, opts))
+	}
+	for _, i := range sortOrder(names, opts.SortBy) {
+		fmt.Fprint(out, formatName(names[i], opts))
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	return problems.err()
+}
+
+// search starts the directory walk and the workers, and returns the channel
+// on which a result is sent for every file searched. The channel is closed
+// once all files are done, or once the walk and the workers have wound down
+// after ctx is cancelled. Errors met along the way are gathered in the
+// returned problems, which are complete once the channel is closed.
+func search(ctx context.Context, patterns []string, roots []string, opts Options) (<-chan fileResult, *problems, error) {
+	s, err := newSearcher(patterns, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	results, problems := s.search(ctx, roots)
+	return results, problems, nil
+}
+
+// search is search for the patterns and options s was made with.
+func (s *Searcher) search(ctx context.Context, roots []string) (<-chan fileResult, *problems) {
+	opts := s.opts
+	problems := newProblems(opts)
+
+	// a little slack on both sides lets the walk, the workers and the
+	// printer each get ahead without waiting on the handoff
+	files := make(chan string, 4*workerCount(opts))
+	results := make(chan fileResult, workerCount(opts))
+
+	// the workers count the files they search, which is reported until
+	// they are all done
+	var progress *progress
+	var walked func(string)
+	stopProgress := make(chan struct{})
+	if opts.Progress {
+		progress = newProgress()
+		walked = progress.entered
+		go progress.report(problems, stopProgress)
+	}
+
+	// workers and the stdin reader all send results, which is closed once
+	// they are finished
+	var wg sync.WaitGroup
+	closeResults := func() {
+		go func() {
+			wg.Wait()
+			close(stopProgress)
+			close(results)
+		}()
+	}
+
+	// standard input is a single stream, there is nothing to walk or share out
+	var paths []string
+	for _, root := range roots {
+		if root == "-" && opts.NameOnly {
+			continue
+		}
+		if root == "-" {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				searchStdin(ctx, s.finder, opts, results, problems)
+			}()
+			continue
+		}
+		paths = append(paths, root)
+	}
+	if len(paths) == 0 {
+		closeResults()
+		return results, problems
+	}
+
+	// every open file or directory holds a slot until it is closed
+	open := make(chan struct{}, opts.MaxOpenFiles)
+	var names matcher
+	if opts.NameOnly {
+		// the walk sends only the files whose path is selected, and
+		// nothing is left to open
+		names = s.finder
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range files {
+				progress.searched()
+				result := fileResult{file: file, count: 1, matches: []Match{{File: file}}}
+				select {
+				case results <- result:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	} else if opts.WalkOrder && !opts.SortFiles {
+		queue := make(chan queuedFile, cap(files))
+		order := make(chan chan fileResult, cap(files))
+		go queueFiles(ctx, files, queue, order)
+		for i := 0; i < workerCount(opts); i++ {
+			wg.Add(1)
+			go orderedWorker(ctx, queue, s.finder, opts, problems, open, progress, &wg)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			releaseInOrder(order, results)
+		}()
+	} else if opts.IOThreads > 0 {
+		// the matchers only finish once loaded is closed, which is after
+		// the last reader is done sending. The readers send results too,
+		// for the files they search themselves, so results is only closed
+		// once they are done as well, even when ctx is cancelled and the
+		// matchers return early.
+		loaded := make(chan loadedFile, workerCount(opts))
+		var readers sync.WaitGroup
+		for i := 0; i < opts.IOThreads; i++ {
+			readers.Add(1)
+			go reader(ctx, files, s.finder, opts, loaded, results, problems, open, progress, &readers)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			readers.Wait()
+			close(loaded)
+		}()
+		for i := 0; i < workerCount(opts); i++ {
+			wg.Add(1)
+			go matchWorker(ctx, loaded, s.finder, opts, results, problems, progress, &wg)
+		}
+	} else {
+		for i := 0; i < workerCount(opts); i++ {
+			wg.Add(1)
+			go worker(ctx, files, s.finder, opts, results, problems, open, progress, &wg)
+		}
 	}
+	closeResults()
+
+	go walkRoots(ctx, paths, opts, s.ignoreRules, files, problems, open, walked, names)
+
+	if opts.SortFiles {
+		return sortedResults(results, opts.SortBy), problems
+	}
+	return results, problems
+}
+
+// workerCount is the number of workers opts asks for.
+func workerCount(opts Options) int {
+	if opts.Threads > 0 {
+		return opts.Threads
+	}
+	return runtime.NumCPU() * max(opts.ThreadsPerCPU, 1)
+}
+
+// searchStdin searches opts.Stdin, or os.Stdin if it is not set, and sends
+// the result on results.
+func searchStdin(ctx context.Context, finder matcher, opts Options, results chan<- fileResult, problems *problems) {
+	stdin := opts.Stdin
+	if stdin == nil {
+		stdin = os.Stdin
+	}
+	if opts.Decompress {
+		var err error
+		if stdin, err = decompress(stdin); err != nil {
+			problems.report(fmt.Errorf("error in decompressing %s: %w", stdinName, err))
+			return
+		}
+	}
+	result, err := scanReader(ctx, stdin, stdinName, finder, opts)
+	if err != nil {
+		problems.report(err)
+	}
+	send(ctx, results, result)
+}
+
+// sortedResults collects every result from results and sends them on again in
+// the order of key once results is closed. Lines within a result are already
+// in file order.
+func sortedResults(results <-chan fileResult, key SortKey) <-chan fileResult {
+	sorted := make(chan fileResult, cap(results))
+	go func() {
+		defer close(sorted)
+
+		var all []fileResult
+		var names []string
+		for result := range results {
+			all = append(all, result)
+			names = append(names, result.file)
+		}
+		for _, i := range sortOrder(names, key) {
+			sorted <- all[i]
+		}
+	}()
+	return sorted
 }
 
 // Below, is Go's internal Boyer-Moore string search algorithm, it has been
 // modified to use []byte instead of string to reduce allocations.
 
+// stringFinder is never changed once it has been made, searching only reads
+// it, so a single one is safe to share between any number of goroutines.
 type stringFinder struct {
 	// pattern is the string that we are searching for in the text.
 	pattern []byte
@@ -102,6 +984,18 @@ type stringFinder struct {
 	// rightmost "abc" (at position 6) is a prefix of the whole pattern, so
 	// goodSuffixSkip[3] == shift+len(suffix) == 6+5 == 11.
 	goodSuffixSkip []int
+
+	// ignoreCase folds ASCII letters in the text before comparing. The
+	// pattern and both tables are built from the lowercased pattern.
+	ignoreCase bool
+
+	// overlapping makes nextAll look for the next occurrence one byte on
+	// from the start of the last, not from its end.
+	overlapping bool
+
+	// prefilter makes next find candidates with nextPrefiltered. Only set
+	// it where canPrefilter allows.
+	prefilter bool
 }
 
 func MakeStringFinder(pattern []byte) *stringFinder {
@@ -156,9 +1050,27 @@ func longestCommonSuffix(a, b []byte) (i int) {
 	return
 }
 
+// MakeFoldedStringFinder returns a stringFinder that matches pattern without
+// regard to ASCII letter case.
+func MakeFoldedStringFinder(pattern []byte) *stringFinder {
+	f := MakeStringFinder(bytes.ToLower(pattern))
+	f.ignoreCase = true
+	return f
+}
+
 // next returns the index in text of the first occurrence of the pattern. If
-// the pattern is not found, it returns -1.
+// the pattern is not found, it returns -1. An empty pattern occurs at the
+// start of any text, so it matches every line like it does in grep.
 func (f *stringFinder) next(text []byte) int {
+	if len(f.pattern) == 0 {
+		return 0
+	}
+	if f.prefilter {
+		return f.nextPrefiltered(text)
+	}
+	if f.ignoreCase {
+		return f.nextFold(text)
+	}
 	i := len(f.pattern) - 1
 	for i < len(text) {
 		// Compare backwards from the end until the first unmatching character.
@@ -175,6 +1087,150 @@ func (f *stringFinder) next(text []byte) int {
 	return -1
 }
 
+// nextFold is next with every byte of text lowered before it is compared or
+// looked up in badCharSkip. Folding on the fly avoids copying each line.
+func (f *stringFinder) nextFold(text []byte) int {
+	i := len(f.pattern) - 1
+	for i < len(text) {
+		j := len(f.pattern) - 1
+		for j >= 0 && toLower(text[i]) == f.pattern[j] {
+			i--
+			j--
+		}
+		if j < 0 {
+			return i + 1 // match
+		}
+		i += max(f.badCharSkip[toLower(text[i])], f.goodSuffixSkip[j])
+	}
+	return -1
+}
+
+// canPrefilter reports whether nextPrefiltered can search for the pattern,
+// which it cannot when the first byte has to match either of two cases.
+func (f *stringFinder) canPrefilter() bool {
+	return len(f.pattern) > 0 && (!f.ignoreCase || toUpper(f.pattern[0]) == f.pattern[0])
+}
+
+// nextPrefiltered is next for Options.PreFilter. Each occurrence of the first
+// byte of the pattern is found with bytes.IndexByte, which is vectorised,
+// and the rest of the pattern is compared only from there.
+func (f *stringFinder) nextPrefiltered(text []byte) int {
+	first, rest := f.pattern[0], f.pattern[1:]
+	for offset := 0; offset+len(f.pattern) <= len(text); {
+		i := bytes.IndexByte(text[offset:len(text)-len(rest)], first)
+		if i == -1 {
+			return -1
+		}
+		start := offset + i
+		if f.equalAt(text[start+1:], rest) {
+			return start
+		}
+		offset = start + 1
+	}
+	return -1
+}
+
+// equalAt reports whether text starts with pattern, folding ASCII letters in
+// text if f ignores case.
+func (f *stringFinder) equalAt(text, pattern []byte) bool {
+	if !f.ignoreCase {
+		return bytes.HasPrefix(text, pattern)
+	}
+	for j, b := range pattern {
+		if toLower(text[j]) != b {
+			return false
+		}
+	}
+	return true
+}
+
+func toUpper(b byte) byte {
+	if 'a' <= b && b <= 'z' {
+		return b - ('a' - 'A')
+	}
+	return b
+}
+
+func toLower(b byte) byte {
+	if 'A' <= b && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+// nextAll returns the index in text of every non-overlapping occurrence of
+// the pattern, or of every occurrence if f is overlapping, leftmost first.
+func (f *stringFinder) nextAll(text []byte) []int {
+	step := len(f.pattern)
+	if f.overlapping {
+		step = 1
+	}
+	var indexes []int
+	offset := 0
+	for offset <= len(text) {
+		i := f.next(text[offset:])
+		if i == -1 {
+			break
+		}
+		indexes = append(indexes, offset+i)
+		// an empty pattern still has to move on by one byte
+		offset += i + max(step, 1)
+	}
+	return indexes
+}
+
+// CountMatches returns the number of non-overlapping occurrences of pattern
+// in text, found with the same Boyer-Moore search the workers use. It agrees
+// with bytes.Count, and does no I/O, so the finder can be benchmarked and
+// checked on its own.
+func CountMatches(pattern, text []byte) int {
+	return countMatches(pattern, text, len(pattern))
+}
+
+// CountOverlapping is CountMatches counting every occurrence of pattern,
+// including those that start inside the one before, so "aa" occurs three
+// times in "aaaa" where CountMatches finds two.
+func CountOverlapping(pattern, text []byte) int {
+	return countMatches(pattern, text, 1)
+}
+
+// countMatches counts the occurrences of pattern in text, looking for each
+// one step bytes on from the start of the last.
+func countMatches(pattern, text []byte, step int) int {
+	if len(pattern) == 0 {
+		return utf8.RuneCount(text) + 1
+	}
+	f := MakeStringFinder(pattern)
+	n := 0
+	for offset := 0; ; {
+		i := f.next(text[offset:])
+		if i == -1 {
+			return n
+		}
+		n++
+		offset += i + step
+	}
+}
+
+func (f *stringFinder) find(text []byte) (int, int) {
+	i := f.next(text)
+	if i == -1 {
+		return -1, -1
+	}
+	return i, i + len(f.pattern)
+}
+
+// done reports whether ctx has been cancelled without blocking. It is cheap
+// enough to call once per scanned line.
+func done(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
+
 func max(a, b int) int {
 	if a > b {
 		return a
@@ -182,46 +1238,435 @@ func max(a, b int) int {
 	return b
 }
 
-func worker(files <-chan string, pattern []byte, results chan<- string, wg *sync.WaitGroup) {
+func worker(ctx context.Context, files <-chan string, finder matcher, opts Options, results chan<- fileResult, problems *problems, open chan struct{}, progress *progress, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	// make a stringFinder for the given pattern
-	finder := MakeStringFinder(pattern)
+	// iterate over all files until there are none left or the search is
+	// cancelled
+	for {
+		var file string
+		select {
+		case <-ctx.Done():
+			return
+		case f, ok := <-files:
+			if !ok {
+				return
+			}
+			file = f
+		}
 
-	// iterate over all files
-	for file := range files {
-		f, err := os.Open(file)
+		if !searchFile(ctx, file, finder, opts, results, problems, open) {
+			return
+		}
+		progress.searched()
+	}
+}
+
+// queuedFile is a file waiting for a worker with Options.WalkOrder, along
+// with the channel its results are to be sent on.
+type queuedFile struct {
+	path    string
+	results chan fileResult
+}
+
+// queueFiles gives every file from the walk its own results channel, and
+// sends the channels on order in the same order as the files on queue.
+func queueFiles(ctx context.Context, files <-chan string, queue chan<- queuedFile, order chan<- chan fileResult) {
+	defer close(queue)
+	defer close(order)
+	for path := range files {
+		// one result fits, so a worker can go on to its next file before
+		// the ones ahead are reported, unless this one is an archive
+		file := queuedFile{path: path, results: make(chan fileResult, 1)}
+		select {
+		case order <- file.results:
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case queue <- file:
+		case <-ctx.Done():
+			// every channel on order has to be closed for
+			// releaseInOrder to finish
+			close(file.results)
+			return
+		}
+	}
+}
+
+// orderedWorker is worker for Options.WalkOrder, sending the results of each
+// file on its own channel and closing it when the file is done.
+func orderedWorker(ctx context.Context, queue <-chan queuedFile, finder matcher, opts Options, problems *problems, open chan struct{}, progress *progress, wg *sync.WaitGroup) {
+	defer wg.Done()
+	// once the search is cancelled the files left will not be searched,
+	// which is as good as done for releaseInOrder
+	defer func() {
+		for file := range queue {
+			close(file.results)
+		}
+	}()
+
+	for {
+		var file queuedFile
+		select {
+		case <-ctx.Done():
+			return
+		case f, ok := <-queue:
+			if !ok {
+				return
+			}
+			file = f
+		}
+
+		ok := searchFile(ctx, file.path, finder, opts, file.results, problems, open)
+		close(file.results)
+		if !ok {
+			return
+		}
+		progress.searched()
+	}
+}
+
+// releaseInOrder sends on the results from each channel on order in turn,
+// moving to the next once the file it belongs to is done.
+func releaseInOrder(order <-chan chan fileResult, results chan<- fileResult) {
+	for file := range order {
+		for result := range file {
+			results <- result
+		}
+	}
+}
+
+// searchFile searches the file at path and sends a result for it, or one for
+// every member if it is an archive. The file is only opened once there is a
+// free slot in open. It returns false if the search was cancelled.
+func searchFile(ctx context.Context, path string, finder matcher, opts Options, results chan<- fileResult, problems *problems, open chan struct{}) bool {
+	select {
+	case open <- struct{}{}:
+		defer func() { <-open }()
+	case <-ctx.Done():
+		return false
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		problems.report(fmt.Errorf("error in opening file: %w", err))
+		return true
+	}
+	defer f.Close()
+
+	if opts.Decompress && isZip(path) {
+		info, err := f.Stat()
 		if err != nil {
-			fmt.Printf("error in opening file: %s\n", err)
+			problems.report(fmt.Errorf("error in opening archive: %w", err))
+			return true
+		}
+		return scanZip(ctx, f, info.Size(), path, finder, opts, results, problems)
+	}
+
+	var r io.Reader = f
+	if opts.Decompress {
+		if r, err = decompress(f); err != nil {
+			problems.report(fmt.Errorf("error in decompressing file %s: %w", path, err))
+			return true
+		}
+		if isTar(path) {
+			return scanTar(ctx, r, path, finder, opts, results, problems)
+		}
+	}
+
+	// a large file that is not compressed is searched where it lies
+	if r == io.Reader(f) {
+		if result, ok, err := scanMapped(ctx, f, path, finder, opts); ok {
+			if err != nil {
+				problems.report(err)
+			}
+			return send(ctx, results, result)
+		}
+	}
+	result, err := scanReader(ctx, r, path, finder, opts)
+	if err != nil {
+		problems.report(err)
+	}
+	return send(ctx, results, result)
+}
+
+// send sends result and reports whether the search goes on. A file cut short
+// by cancelling ctx is still sent with what was found in it, as every
+// reader of results drains it until it is closed. Each result is local to
+// the worker that made it, so it needs no locking.
+func send(ctx context.Context, results chan<- fileResult, result fileResult) bool {
+	results <- result
+	return ctx.Err() == nil
+}
+
+// appendEachMatch appends a Match to dst for every non-empty match of finder
+// in text, the selected line lineNumber of name starting at offset. With
+// OnlyMatching its Line is just the matched text, and its ByteOffset that of
+// the match, otherwise the whole line.
+func appendEachMatch(dst []Match, name string, lineNumber int, offset int64, text []byte, finder matcher, opts Options) []Match {
+	if opts.Replace != nil {
+		return appendEachReplaced(dst, name, lineNumber, offset, text, finder, opts)
+	}
+	for _, span := range findAll(finder, text) {
+		if span[0] == span[1] {
 			continue
 		}
+		m := Match{File: name, LineNumber: lineNumber, ByteOffset: offset, Line: string(text), Column: span[0] + 1}
+		if opts.RuneColumn {
+			m.Column = utf8.RuneCount(text[:span[0]]) + 1
+		}
+		if opts.OnlyMatching {
+			m.Line = string(text[span[0]:span[1]])
+			m.ByteOffset += int64(span[0])
+			span = [2]int{0, span[1] - span[0]}
+		}
+		if wantSpans(opts) {
+			m.spans = [][2]int{span}
+		}
+		dst = append(dst, m)
+	}
+	return dst
+}
 
-		scanner := bufio.NewScanner(f)
-		lineNumber := 1
-		isBinary := false
+// readerPool and lineBufferPool recycle the buffers scanReader needs for
+// every file, which would otherwise be most of what a search of many small
+// files allocates.
+var (
+	readerPool = sync.Pool{
+		New: func() any { return bufio.NewReaderSize(nil, binaryPeekSize) },
+	}
+	lineBufferPool = sync.Pool{
+		New: func() any {
+			buf := make([]byte, 4<<10)
+			return &buf
+		},
+	}
+)
 
-		for scanner.Scan() {
-			text := scanner.Bytes()
-			if lineNumber == 1 {
-				if bytes.IndexByte(text, 0) != -1 {
-					isBinary = true
-				}
+// scanLines is a bufio.SplitFunc returning lines without their "\n" or
+// "\r\n" ending, so that files with Windows line endings match patterns
+// anchored to the end of the line. A lone "\r" is kept as part of the line.
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, bytes.TrimSuffix(data[:i], []byte("\r")), nil
+	}
+	// a final line without a newline may still end in "\r"
+	if atEOF {
+		return len(data), bytes.TrimSuffix(data, []byte("\r")), nil
+	}
+	return 0, nil, nil
+}
+
+// lineEnding returns the ending scanLines took off a line as one of a few
+// constant strings, so that keeping it for each Match costs nothing.
+func lineEnding(ending []byte) string {
+	switch string(ending) {
+	case "\r\n":
+		return "\r\n"
+	case "\n":
+		return "\n"
+	case "\r":
+		return "\r"
+	}
+	return ""
+}
+
+// setEnding sets the ending of every match in ms, all found on one line.
+func setEnding(ms []Match, ending string) {
+	for i := range ms {
+		ms[i].ending = ending
+	}
+}
+
+// scanReader searches r line by line, reporting matches under name. If reading
+// fails part way, the result so far is returned along with the error.
+func scanReader(ctx context.Context, r io.Reader, name string, finder matcher, opts Options) (fileResult, error) {
+	pooled := readerPool.Get().(*bufio.Reader)
+	pooled.Reset(r)
+	defer func() {
+		pooled.Reset(nil)
+		readerPool.Put(pooled)
+	}()
+
+	// UTF-16 text, known by its byte order mark, is searched as UTF-8 so
+	// its NUL bytes don't make it look binary
+	reader := pooled
+	if opts.Encoding != "" {
+		reader = bufio.NewReaderSize(decodeAs(pooled, opts.Encoding), binaryPeekSize)
+	} else if decoded, ok := decodeUTF16(pooled); ok {
+		reader = bufio.NewReaderSize(decoded, binaryPeekSize)
+	}
+
+	if opts.Multiline {
+		data, err := io.ReadAll(reader)
+		result := scanMultiline(ctx, data, name, finder, opts)
+		if err != nil {
+			return result, fmt.Errorf("error in reading file %s: %w", name, err)
+		}
+		return result, nil
+	}
+
+	// look at the start of the input for a NUL byte before scanning, the
+	// first line alone is often printable even in a binary
+	head, _ := reader.Peek(binaryPeekSize)
+	isBinary := !opts.Text && bytes.IndexByte(head, 0) != -1
+
+	// the buffer starts small and only grows up to the limit when a
+	// long line is actually seen. A grown buffer is left to the garbage
+	// collector rather than pooled, so one long line doesn't pin memory.
+	buf := lineBufferPool.Get().(*[]byte)
+	defer lineBufferPool.Put(buf)
+	lines := &readerLines{Scanner: bufio.NewScanner(reader)}
+	// the initial capacity also caps the line length, so it must not be
+	// more than the limit
+	lines.Buffer((*buf)[:0:min(len(*buf), opts.MaxLineSize)], opts.MaxLineSize)
+	// the split function records how many bytes each line took up with
+	// its ending, which counts two bytes for "\r\n" and none for a last
+	// line without one, and the ending itself
+	lines.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		n, token, err := scanLines(data, atEOF)
+		if token != nil {
+			lines.size = int64(n)
+			lines.ending = lineEnding(data[len(token):n])
+		}
+		return n, token, err
+	})
+	return scanLinesOf(ctx, lines, name, finder, opts, isBinary, 1, 0)
+}
+
+// lineScanner is where scanLinesOf reads lines from.
+type lineScanner interface {
+	// Scan moves on to the next line, returning false at the end of the
+	// input or on an error.
+	Scan() bool
+
+	// Bytes returns the current line without its ending.
+	Bytes() []byte
+
+	// Size returns how many bytes the current line took up in the input,
+	// its ending included.
+	Size() int64
+
+	// Ending returns the ending of the current line, see Match.ending.
+	Ending() string
+
+	Err() error
+}
+
+// readerLines is a lineScanner reading through a bufio.Scanner, whose split
+// function sets size and ending.
+type readerLines struct {
+	*bufio.Scanner
+	size   int64
+	ending string
+}
+
+func (l *readerLines) Size() int64 {
+	return l.size
+}
+
+func (l *readerLines) Ending() string {
+	return l.ending
+}
+
+// scanLinesOf searches the lines of lines, reporting matches under name.
+// isBinary is whether the input has already been found to hold a NUL byte.
+// lineNumber and offset are those of the first line, which is not the start
+// of the file when it is searched in pieces.
+func scanLinesOf(ctx context.Context, lines lineScanner, name string, finder matcher, opts Options, isBinary bool, lineNumber int, offset int64) (fileResult, error) {
+	// offset is where the next line starts in the input
+	result := fileResult{file: name}
+	first := offset
+
+	// unprinted lines kept for leading context, and how many more lines
+	// are still owed as trailing context of the last selected line
+	before := newLineRing(opts.Before)
+	afterLeft := 0
+
+	for lines.Scan() {
+		if done(ctx) {
+			break
+		}
+		lineOffset := offset
+		offset += lines.Size()
+
+		text, ending := lines.Bytes(), lines.Ending()
+		// a NUL further into the input also makes it binary from here on
+		if !isBinary && !opts.Text && bytes.IndexByte(text, 0) != -1 {
+			isBinary = true
+		}
+
+		// past the maximum only the trailing context of the last selected
+		// line is still read, like grep
+		if opts.MaxCount > 0 && result.count >= opts.MaxCount {
+			if afterLeft == 0 || isBinary {
+				break
+			}
+			result.matches = append(result.matches, Match{File: name, LineNumber: lineNumber, ByteOffset: lineOffset, Line: string(text), Context: true, ending: ending})
+			afterLeft--
+			lineNumber++
+			continue
+		}
+
+		// a line is selected when its match state differs from invert;
+		// for binary files this means "matches" refers to selected lines
+		start, _ := finder.find(text)
+		if (start != -1) != opts.Invert {
+			result.count++
+			if opts.FilesWithMatches || opts.FilesWithoutMatch || opts.Quiet {
+				// one selected line is enough to decide whether the file is
+				// listed, only files without any are read to the end
+				break
 			}
-			
-			if finder.next(text) != -1 {
+			if !opts.Count {
 				if isBinary {
-					results <- fmt.Sprintf("Binary file %s matches\n", file)
+					result.matches = append(result.matches, Match{File: name, ByteOffset: lineOffset + int64(max(start, 0)), Binary: true})
 					break
+				} else if opts.Preview > 0 && result.count > opts.Preview {
+					// past the preview lines are only counted, and the
+					// context of the last one shown ends here
+					result.hidden++
+					afterLeft = 0
+				} else if opts.OnlyMatching || opts.Vimgrep && start != -1 {
+					n := len(result.matches)
+					result.matches = appendEachMatch(result.matches, name, lineNumber, lineOffset, text, finder, opts)
+					setEnding(result.matches[n:], ending)
 				} else {
-					results <- fmt.Sprintf("%s:%d %s\n", file, lineNumber, scanner.Text())
+					result.matches = before.drain(result.matches)
+					m := Match{File: name, LineNumber: lineNumber, ByteOffset: lineOffset, Line: string(text), ending: ending}
+					if start != -1 {
+						m.Column = start + 1
+						if opts.RuneColumn {
+							m.Column = utf8.RuneCount(text[:start]) + 1
+						}
+						if wantSpans(opts) {
+							m.spans = findAll(finder, text)
+						}
+						if opts.Replace != nil {
+							m.Line, m.spans = replaceLine(finder, text, *opts.Replace)
+						}
+					}
+					result.matches = append(result.matches, m)
+					afterLeft = opts.After
 				}
 			}
-			lineNumber++
-		}
-		if err := scanner.Err(); err != nil {
-			fmt.Printf("error in reading file %s:%d \t %v\n", file, lineNumber, err)
+		} else if !opts.Count && !opts.FilesWithMatches && !opts.FilesWithoutMatch && !isBinary {
+			if afterLeft > 0 {
+				result.matches = append(result.matches, Match{File: name, LineNumber: lineNumber, ByteOffset: lineOffset, Line: string(text), Context: true, ending: ending})
+				afterLeft--
+			} else {
+				before.push(name, lineNumber, lineOffset, text, ending)
+			}
 		}
-
-		f.Close()
+		lineNumber++
+	}
+	result.size = offset - first
+	if err := lines.Err(); err != nil {
+		return result, fmt.Errorf("error in reading file %s:%d: %w", name, lineNumber, err)
 	}
+	return result, nil
 }
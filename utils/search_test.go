@@ -0,0 +1,294 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// grepFile writes content to a file of its own and returns what
+// ConcurrentGrep prints for patterns in it, without the file name.
+func grepFile(t *testing.T, content string, opts Options, patterns ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	opts.Output = &out
+	opts.NoFilename = true
+	if _, err := ConcurrentGrep(patterns, []string{path}, opts); err != nil {
+		t.Fatalf("ConcurrentGrep(%q): %v", patterns, err)
+	}
+	return out.String()
+}
+
+func TestDeprecatedSearch(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("foo\nbar\nfoo bar\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want, err := SearchRoots("foo", []string{dir}, WithPatterns("bar"), WithThreads(2))
+	if err != nil {
+		t.Fatalf("SearchRoots: %v", err)
+	}
+	got, err := Search([]string{"foo", "bar"}, dir, Options{Threads: 2})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(got) != 3 || !slices.EqualFunc(got, want, func(a, b Match) bool {
+		return a.File == b.File && a.LineNumber == b.LineNumber && a.Line == b.Line
+	}) {
+		t.Errorf("Search = %v, SearchRoots = %v", got, want)
+	}
+}
+
+func TestSearchThreads(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, "a", "b/c")
+	for _, name := range []string{"a", "b/c"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x\nfoo\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, threads := range []int{1, 4} {
+		matches, err := Search([]string{"foo"}, dir, Options{Threads: threads})
+		if err != nil {
+			t.Fatalf("Search with %d threads: %v", threads, err)
+		}
+		slices.SortFunc(matches, func(a, b Match) int { return strings.Compare(a.File, b.File) })
+		var got []string
+		for _, m := range matches {
+			rel, _ := filepath.Rel(dir, m.File)
+			got = append(got, fmt.Sprintf("%s:%d:%s", filepath.ToSlash(rel), m.LineNumber, m.Line))
+		}
+		if want := []string{"a:2:foo", "b/c:2:foo"}; !slices.Equal(got, want) {
+			t.Errorf("Search with %d threads = %q, want %q", threads, got, want)
+		}
+	}
+}
+
+func TestLongLine(t *testing.T) {
+	line := strings.Repeat("x", 1<<19) + "needle" + strings.Repeat("x", 1<<19)
+	content := "before\n" + line + "\nafter\n"
+
+	matches, err := GrepReader(strings.NewReader(content), "long", []string{"needle"}, Options{})
+	if err != nil {
+		t.Fatalf("GrepReader: %v", err)
+	}
+	if len(matches) != 1 || matches[0].LineNumber != 2 || matches[0].Line != line {
+		t.Fatalf("got %d matches, want line 2 of %d bytes", len(matches), len(line))
+	}
+
+	// a line longer than MaxLineSize stops the file with an error, keeping
+	// what was found before it
+	content = "needle\n" + line + "\nneedle\n"
+	matches, err = GrepReader(strings.NewReader(content), "long", []string{"needle"}, Options{MaxLineSize: 1 << 16})
+	if !errors.Is(err, bufio.ErrTooLong) {
+		t.Fatalf("got error %v, want %v", err, bufio.ErrTooLong)
+	}
+	if len(matches) != 1 || matches[0].LineNumber != 1 {
+		t.Errorf("got %d matches, want only line 1", len(matches))
+	}
+}
+
+func TestContextWindows(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		opts    Options
+		want    string
+	}{
+		{"overlapping", "a\nfoo\nb\nfoo\nc\nd\n", Options{Before: 1, After: 1}, "1-a\n2:foo\n3-b\n4:foo\n5-c\n"},
+		{"match inside the window before", "foo\nfoo\na\n", Options{Before: 2}, "1:foo\n2:foo\n"},
+		{"adjacent", "a\nfoo\nb\nc\nfoo\nd\n", Options{Before: 1, After: 1}, "1-a\n2:foo\n3-b\n4-c\n5:foo\n6-d\n"},
+		{"separated", "a\nfoo\nb\nc\nd\nfoo\ne\n", Options{Before: 1, After: 1}, "1-a\n2:foo\n3-b\n--\n5-d\n6:foo\n7-e\n"},
+		{"separated after only", "foo\na\nb\nfoo\n", Options{After: 1}, "1:foo\n2-a\n--\n4:foo\n"},
+		{"separated before only", "foo\na\nb\nfoo\n", Options{Before: 1}, "1:foo\n--\n3-b\n4:foo\n"},
+		{"group separator", "foo\na\nb\nfoo\n", Options{After: 1, GroupSeparator: "=="}, "1:foo\n2-a\n==\n4:foo\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := grepFile(t, tt.content, tt.opts, "foo"); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAfterContextAtEnd(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"match on the last line", "a\nb\nfoo\n", "3:foo\n"},
+		{"last line without a newline", "a\nfoo\nb\nfoo", "2:foo\n3-b\n4:foo\n"},
+		{"fewer lines after than asked for", "foo\na\nb\n", "1:foo\n2-a\n3-b\n"},
+		{"context without a newline", "foo\na\nb", "1:foo\n2-a\n3-b\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := grepFile(t, tt.content, Options{After: 5}, "foo"); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCRLF(t *testing.T) {
+	const content = "foo\r\nfoo bar\r\nbar foo\r\n"
+	tests := []struct {
+		name    string
+		pattern string
+		opts    Options
+		want    string
+	}{
+		{"line regexp", "foo", Options{LineRegexp: true}, "1:foo\n"},
+		{"line regexp regex", "fo+", Options{LineRegexp: true, Regex: true}, "1:foo\n"},
+		{"line end", "foo", Options{LineEnd: true}, "1:foo\n3:bar foo\n"},
+		{"dollar", "foo$", Options{Regex: true}, "1:foo\n3:bar foo\n"},
+		{"only matching at the end", "o$", Options{Regex: true, OnlyMatching: true}, "1:o\n3:o\n"},
+		{"multiline line end", "foo", Options{LineEnd: true, Multiline: true}, "1:foo\n3:bar foo\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := grepFile(t, content, tt.opts, tt.pattern); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmptyPattern(t *testing.T) {
+	const content = "a\n\nb\n"
+	tests := []struct {
+		name     string
+		patterns []string
+		opts     Options
+		want     string
+	}{
+		{"every line", []string{""}, Options{}, "1:a\n2:\n3:b\n"},
+		{"with another", []string{"", "b"}, Options{}, "1:a\n2:\n3:b\n"},
+		{"invert", []string{""}, Options{Invert: true}, ""},
+		// an empty match has nothing to print
+		{"only matching", []string{""}, Options{OnlyMatching: true}, ""},
+		{"only matching with another", []string{"", "a"}, Options{OnlyMatching: true}, "1:a\n"},
+		{"line regexp", []string{""}, Options{LineRegexp: true}, "2:\n"},
+		{"line regexp inverted", []string{""}, Options{LineRegexp: true, Invert: true}, "1:a\n3:b\n"},
+		{"regex", []string{""}, Options{Regex: true}, "1:a\n2:\n3:b\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := grepFile(t, content, tt.opts, tt.patterns...); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if n := CountMatches(nil, []byte("ab")); n != 3 {
+		t.Errorf("CountMatches of the empty pattern in %q = %d, want 3", "ab", n)
+	}
+}
+
+// smallFile is a typical source file, of the kind a search of a large tree
+// reads hundreds of thousands of.
+var smallFile = []byte(strings.Repeat("func main() { fmt.Println(\"hello, world\") }\n", 50))
+
+// BenchmarkScanSmallFiles times scanReader on one small file, and how much
+// it allocates, with its buffers pooled as in a search.
+func BenchmarkScanSmallFiles(b *testing.B) {
+	benchmarkScanSmallFiles(b, false)
+}
+
+// BenchmarkScanSmallFilesUnpooled is BenchmarkScanSmallFiles with the pools
+// emptied before each file, as if the buffers were allocated for every file.
+func BenchmarkScanSmallFilesUnpooled(b *testing.B) {
+	benchmarkScanSmallFiles(b, true)
+	emptyScanPools()
+}
+
+func benchmarkScanSmallFiles(b *testing.B, unpooled bool) {
+	opts := scanDefaults(Options{})
+	finder := MakeStringFinder([]byte("needle"))
+	r := bytes.NewReader(smallFile)
+	b.SetBytes(int64(len(smallFile)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if unpooled {
+			emptyScanPools()
+		}
+		r.Reset(smallFile)
+		if _, err := scanReader(context.Background(), r, "small", finder, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// emptyScanPools replaces the pools of scanReader with empty ones.
+func emptyScanPools() {
+	readerPool = sync.Pool{New: readerPool.New}
+	lineBufferPool = sync.Pool{New: lineBufferPool.New}
+}
+
+func TestMultilineMaxCount(t *testing.T) {
+	const content = "foo\nbar\nfoo\nbar\n"
+	tests := []struct {
+		name     string
+		maxCount int
+		want     string
+	}{
+		{"first line of a match", 1, "1:foo\n"},
+		{"whole match", 2, "1:foo\n2:bar\n"},
+		{"into the second match", 3, "1:foo\n2:bar\n3:foo\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{Multiline: true, Regex: true, MaxCount: tt.maxCount}
+			if got := grepFile(t, content, opts, `foo\nbar`); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCancelledSummary(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, "a", "b")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name           string
+		opts           Options
+		output, errors bool
+	}{
+		{"without stats", Options{}, false, true},
+		{"with stats", Options{Stats: true}, true, false},
+		{"quiet", Options{Quiet: true}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out, errOut bytes.Buffer
+			opts := tt.opts
+			opts.Output, opts.ErrOutput = &out, &errOut
+			if _, err := ConcurrentGrepContext(ctx, []string{"pattern"}, []string{dir}, opts); !errors.Is(err, context.Canceled) {
+				t.Fatalf("ConcurrentGrepContext: %v, want %v", err, context.Canceled)
+			}
+			if got := strings.Contains(out.String(), "files searched"); got != tt.output {
+				t.Errorf("summary in the output %q: %t, want %t", out.String(), got, tt.output)
+			}
+			if got := strings.Contains(errOut.String(), "files searched"); got != tt.errors {
+				t.Errorf("summary in the errors %q: %t, want %t", errOut.String(), got, tt.errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"context"
+	"errors"
+)
+
+// Searcher searches for the same patterns with the same Options again and
+// again, as a server might under many directories over time. The patterns
+// are compiled once, when the Searcher is made, into a matcher shared by
+// every call and every worker, which only ever read it. A Searcher is safe
+// for concurrent use.
+type Searcher struct {
+	opts        Options
+	finder      matcher
+	ignoreRules []ignoreRule
+}
+
+// NewSearcher compiles patterns for searching with opts. Lines matching any
+// of them are selected. It returns an error if a pattern or anything else in
+// opts is invalid. Options.IgnoreFiles are read now, not on every search.
+func NewSearcher(patterns []string, opts Options) (*Searcher, error) {
+	return newSearcher(patterns, everyLine(opts))
+}
+
+// newSearcher makes the Searcher behind NewSearcher and ConcurrentGrep,
+// keeping the settings of opts that only change how ConcurrentGrep prints.
+func newSearcher(patterns []string, opts Options) (*Searcher, error) {
+	if len(patterns) == 0 {
+		return nil, errors.New("no pattern given")
+	}
+
+	// compile the regexes once up front so a bad pattern is reported to the
+	// caller instead of every worker failing on its own
+	res, err := compilePatterns(patterns, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkGlobs(opts.Include); err != nil {
+		return nil, err
+	}
+	if err := checkGlobs(opts.Exclude); err != nil {
+		return nil, err
+	}
+	if err := checkTypes(opts); err != nil {
+		return nil, err
+	}
+	if err := checkEncoding(opts.Encoding); err != nil {
+		return nil, err
+	}
+	ignoreRules, err := readIgnoreFiles(opts.IgnoreFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	opts = scanDefaults(opts)
+	return &Searcher{
+		opts:        opts,
+		finder:      newMatcher(patterns, res, opts),
+		ignoreRules: ignoreRules,
+	}, nil
+}
+
+// Search returns every line under root selected by the patterns of s, like
+// SearchRoots. A root of "-" searches Options.Stdin.
+func (s *Searcher) Search(root string) ([]Match, error) {
+	return s.SearchContext(context.Background(), root)
+}
+
+// SearchContext is Search, stopping early when ctx is cancelled, in which
+// case it returns the matches found so far along with ctx.Err().
+func (s *Searcher) SearchContext(ctx context.Context, root string) ([]Match, error) {
+	return s.matches(ctx, []string{root})
+}
+
+// matches collects every line under roots selected by s.
+func (s *Searcher) matches(ctx context.Context, roots []string) ([]Match, error) {
+	results, problems := s.search(ctx, roots)
+
+	var matches []Match
+	for result := range results {
+		matches = append(matches, result.matches...)
+	}
+	if err := ctx.Err(); err != nil {
+		return matches, err
+	}
+	return matches, problems.err()
+}
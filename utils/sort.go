@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// SortKey is what Options.SortFiles sorts files by.
+type SortKey int
+
+const (
+	// SortPath sorts files by path.
+	SortPath SortKey = iota
+	// SortModified sorts files by when they were last modified, oldest
+	// first.
+	SortModified
+	// SortSize sorts files by size, smallest first.
+	SortSize
+)
+
+// ParseSortKey parses "path", "modified" or "size" into a SortKey.
+func ParseSortKey(s string) (SortKey, error) {
+	switch s {
+	case "path":
+		return SortPath, nil
+	case "modified":
+		return SortModified, nil
+	case "size":
+		return SortSize, nil
+	}
+	return 0, fmt.Errorf("invalid sort %q, want path, modified or size", s)
+}
+
+// sortOrder returns the indices of names in the order key puts the files
+// they name in. Files that are equal by key are sorted by path.
+func sortOrder(names []string, key SortKey) []int {
+	type entry struct {
+		name     string
+		modified time.Time
+		size     int64
+	}
+	entries := make([]entry, len(names))
+	for i, name := range names {
+		entries[i].name = name
+		if key != SortPath {
+			if info, ok := statFile(name); ok {
+				entries[i].modified, entries[i].size = info.ModTime(), info.Size()
+			}
+		}
+	}
+
+	order := make([]int, len(names))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := entries[order[i]], entries[order[j]]
+		switch {
+		case key == SortModified && !a.modified.Equal(b.modified):
+			return a.modified.Before(b.modified)
+		case key == SortSize && a.size != b.size:
+			return a.size < b.size
+		}
+		return a.name < b.name
+	})
+	return order
+}
+
+// statFile returns the file info of the file name was reported under. A
+// member of an archive, named "archive.zip/member", is taken to be as old
+// and as large as its archive.
+func statFile(name string) (os.FileInfo, bool) {
+	for {
+		if info, err := os.Stat(name); err == nil {
+			return info, true
+		}
+		parent := filepath.Dir(name)
+		if parent == name || parent == "." {
+			return nil, false
+		}
+		name = parent
+	}
+}
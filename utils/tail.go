@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+	"unicode/utf8"
+)
+
+// tailPollInterval is how long Tail waits at the end of the file before
+// looking for more.
+const tailPollInterval = 250 * time.Millisecond
+
+// Tail searches the file at path like ConcurrentGrep, then keeps reading
+// lines as they are appended and prints those selected, like
+// "tail -f | grep". If the file is truncated or replaced, as when a log is
+// rotated, it starts again from the beginning of the new file. It runs
+// until ctx is cancelled. Context lines, counting, listing files and
+// Options.Encoding are not supported.
+func Tail(ctx context.Context, patterns []string, path string, opts Options) error {
+	if len(patterns) == 0 {
+		return errors.New("no pattern given")
+	}
+	res, err := compilePatterns(patterns, opts)
+	if err != nil {
+		return err
+	}
+	opts = scanDefaults(everyLine(opts))
+	finder := newMatcher(patterns, res, opts)
+
+	output := opts.Output
+	if output == nil {
+		output = os.Stdout
+	}
+	p := newPrinter(output, opts)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error in opening file: %w", err)
+	}
+	defer func() { f.Close() }()
+
+	reader := bufio.NewReader(f)
+	var partial []byte
+	var offset int64
+	lineNumber := 1
+
+	for {
+		// a file growing faster than it is read may never be caught up
+		// with, so cancelling is not only noticed at the end of it
+		if ctx.Err() != nil {
+			return p.out.Flush()
+		}
+		chunk, err := reader.ReadSlice('\n')
+		partial = append(partial, chunk...)
+		if err == bufio.ErrBufferFull {
+			if len(partial) > opts.MaxLineSize {
+				return fmt.Errorf("error in reading file %s:%d: %w", path, lineNumber, bufio.ErrTooLong)
+			}
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("error in reading file %s:%d: %w", path, lineNumber, err)
+		}
+
+		// a line is only complete once its newline has been written
+		if err == nil {
+			line := bytes.TrimSuffix(bytes.TrimSuffix(partial, []byte("\n")), []byte("\r"))
+			matches := tailMatches(path, lineNumber, offset, line, finder, opts)
+			setEnding(matches, lineEnding(partial[len(line):]))
+			if len(matches) > 0 {
+				if err := p.print(fileResult{file: path, matches: matches, count: 1}); err != nil {
+					return err
+				}
+			}
+			offset += int64(len(partial))
+			lineNumber++
+			partial = partial[:0]
+			continue
+		}
+
+		// caught up with the writer
+		if err := p.out.Flush(); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(tailPollInterval):
+		}
+
+		// a rotated log is a new file at the same path, a truncated one is
+		// shorter than what has been read
+		current, statErr := os.Stat(path)
+		if statErr != nil {
+			// the new file may not have been created yet
+			continue
+		}
+		opened, statErr := f.Stat()
+		if statErr != nil {
+			return fmt.Errorf("error in reading file %s: %w", path, statErr)
+		}
+		if os.SameFile(current, opened) && current.Size() >= offset+int64(len(partial)) {
+			continue
+		}
+
+		next, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		f.Close()
+		f = next
+		reader.Reset(f)
+		partial = partial[:0]
+		offset = 0
+		lineNumber = 1
+	}
+}
+
+// tailMatches returns what is reported for one line read by Tail, nothing
+// if it is not selected.
+func tailMatches(path string, lineNumber int, offset int64, text []byte, finder matcher, opts Options) []Match {
+	start, _ := finder.find(text)
+	if (start != -1) == opts.Invert {
+		return nil
+	}
+	if opts.OnlyMatching || opts.Vimgrep && start != -1 {
+		return appendEachMatch(nil, path, lineNumber, offset, text, finder, opts)
+	}
+
+	m := Match{File: path, LineNumber: lineNumber, ByteOffset: offset, Line: string(text)}
+	if start != -1 {
+		m.Column = start + 1
+		if opts.RuneColumn {
+			m.Column = utf8.RuneCount(text[:start]) + 1
+		}
+		if wantSpans(opts) {
+			m.spans = findAll(finder, text)
+		}
+		if opts.Replace != nil {
+			m.Line, m.spans = replaceLine(finder, text, *opts.Replace)
+		}
+	}
+	return []Match{m}
+}
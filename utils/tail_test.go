@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cancellingWriter cancels a context on the first write to it, and counts
+// the bytes written from then on.
+type cancellingWriter struct {
+	cancel  context.CancelFunc
+	written atomic.Int64
+}
+
+func (w *cancellingWriter) Write(p []byte) (int, error) {
+	w.cancel()
+	w.written.Add(int64(len(p)))
+	return len(p), nil
+}
+
+func TestTailCancelWhileGrowing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	line := []byte("a line with the needle in it\n")
+	backlog := bytes.Repeat(line, 1<<16)
+	if err := os.WriteFile(path, backlog, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file keeps growing for as long as Tail runs
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stop := make(chan struct{})
+	appended := make(chan struct{})
+	go func() {
+		defer close(appended)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := f.Write(backlog[:len(line)*64]); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-appended
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := &cancellingWriter{cancel: cancel}
+	done := make(chan error, 1)
+	go func() {
+		done <- Tail(ctx, []string{"needle"}, path, Options{Output: out, NoFilename: true})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Tail: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Tail still running 10s after it was cancelled")
+	}
+	// what was already buffered when the first write cancelled is still
+	// printed, but no more than that
+	if n := out.written.Load(); n > 2*64<<10 {
+		t.Errorf("%d bytes printed after cancelling", n)
+	}
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FileTypes maps the name of a type of file, such as "go", to the globs its
+// base names match, like the types of ripgrep.
+type FileTypes map[string][]string
+
+// defaultFileTypes are the types known without Options.FileTypes.
+var defaultFileTypes = FileTypes{
+	"c":        {"*.c", "*.h"},
+	"cpp":      {"*.cc", "*.cpp", "*.cxx", "*.hh", "*.hpp", "*.hxx", "*.h"},
+	"csharp":   {"*.cs"},
+	"css":      {"*.css", "*.scss", "*.sass", "*.less"},
+	"docker":   {"Dockerfile", "*.dockerfile"},
+	"go":       {"*.go"},
+	"html":     {"*.html", "*.htm"},
+	"java":     {"*.java"},
+	"js":       {"*.js", "*.jsx", "*.mjs", "*.cjs"},
+	"json":     {"*.json"},
+	"kotlin":   {"*.kt", "*.kts"},
+	"make":     {"Makefile", "makefile", "GNUmakefile", "*.mk"},
+	"markdown": {"*.md", "*.markdown"},
+	"md":       {"*.md", "*.markdown"},
+	"php":      {"*.php"},
+	"proto":    {"*.proto"},
+	"py":       {"*.py", "*.pyi"},
+	"ruby":     {"*.rb", "Gemfile", "Rakefile"},
+	"rust":     {"*.rs"},
+	"sh":       {"*.sh", "*.bash", "*.zsh"},
+	"sql":      {"*.sql"},
+	"swift":    {"*.swift"},
+	"toml":     {"*.toml"},
+	"ts":       {"*.ts", "*.tsx", "*.mts", "*.cts"},
+	"txt":      {"*.txt"},
+	"xml":      {"*.xml"},
+	"yaml":     {"*.yaml", "*.yml"},
+}
+
+// DefaultFileTypes returns a copy of the built in types, which can be added
+// to without changing them.
+func DefaultFileTypes() FileTypes {
+	types := make(FileTypes, len(defaultFileTypes))
+	for name, globs := range defaultFileTypes {
+		types[name] = append([]string(nil), globs...)
+	}
+	return types
+}
+
+// Add adds a glob to a type, creating it if there is none by that name,
+// from a definition such as "foo:*.foo".
+func (t FileTypes) Add(spec string) error {
+	name, glob, ok := strings.Cut(spec, ":")
+	if !ok || name == "" || glob == "" {
+		return fmt.Errorf("invalid type definition %q, want name:glob", spec)
+	}
+	if err := checkGlobs([]string{glob}); err != nil {
+		return err
+	}
+	t[name] = append(t[name], glob)
+	return nil
+}
+
+// fileTypes returns the types opts refers to, the defaults if it has none.
+func fileTypes(opts Options) FileTypes {
+	if opts.FileTypes != nil {
+		return opts.FileTypes
+	}
+	return defaultFileTypes
+}
+
+// checkTypes reports the first of Options.Types and Options.TypesNot that is
+// not a known type.
+func checkTypes(opts Options) error {
+	types := fileTypes(opts)
+	for _, name := range append(append([]string(nil), opts.Types...), opts.TypesNot...) {
+		if _, ok := types[name]; !ok {
+			return fmt.Errorf("unknown file type %q", name)
+		}
+	}
+	return nil
+}
+
+// matchesType reports whether the base name matches a glob of any of the
+// types called names. The names must already have been validated with
+// checkTypes.
+func matchesType(opts Options, names []string, name string) bool {
+	types := fileTypes(opts)
+	for _, typ := range names {
+		if matchesAny(types[typ], name) {
+			return true
+		}
+	}
+	return false
+}
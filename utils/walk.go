@@ -0,0 +1,294 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// walker walks the tree under root, sending every file that passes the
+// filters in opts on files. Directories are listed concurrently, so on slow
+// filesystems the workers are not left waiting on a single walk, unless
+// Options.WalkOrder asks for one directory at a time.
+type walker struct {
+	ctx      context.Context
+	root     string
+	opts     Options
+	ignore   *ignoreMatcher
+	problems *problems
+	files    chan<- string
+
+	// seen, if set, holds the files already sent, for Options.Dedup.
+	seen *fileSet
+
+	// dirs, if set, is called with every directory entered. It may be
+	// called from several goroutines at once.
+	dirs func(path string)
+
+	// names, if set, is matched against the path of each file below the
+	// root for Options.NameOnly, and only the files it selects are sent.
+	names matcher
+
+	// open is shared with the workers to bound the number of files and
+	// directories open at once.
+	open chan struct{}
+
+	// wg counts the directories still to be walked.
+	wg sync.WaitGroup
+
+	// visited holds the resolved paths of the directories entered so far
+	// when following symlinks, to break cycles. It is guarded by mu.
+	mu      sync.Mutex
+	visited map[string]bool
+}
+
+// walkRoots walks each of roots in turn and closes files once they are all
+// done, or the walk is cancelled. dirs is passed on to each walker.
+func walkRoots(ctx context.Context, roots []string, opts Options, ignoreRules []ignoreRule, files chan<- string, problems *problems, open chan struct{}, dirs func(string), names matcher) {
+	defer close(files)
+	var seen *fileSet
+	if opts.Dedup {
+		seen = newFileSet()
+	}
+	for _, root := range roots {
+		if ctx.Err() != nil {
+			return
+		}
+		walkRoot(ctx, root, opts, ignoreRules, files, problems, open, dirs, names, seen)
+	}
+}
+
+// walkRoot sends root on files if it is a file, otherwise every file that
+// passes the filters in opts under it. A file named as a root is searched
+// whatever the filters say, like grep. ignoreRules are those of
+// Options.IgnoreFiles. Files already in seen, if it is set, are skipped. It
+// returns once the whole tree has been walked.
+func walkRoot(ctx context.Context, root string, opts Options, ignoreRules []ignoreRule, files chan<- string, problems *problems, open chan struct{}, dirs func(string), names matcher, seen *fileSet) {
+	info, err := os.Stat(root)
+	if err != nil {
+		problems.report(fmt.Errorf("error in walking directory: %w", err))
+		return
+	}
+	if !info.IsDir() {
+		if names != nil && !wantName(names, opts, root) {
+			return
+		}
+		if seen != nil && !seen.add(root, info) {
+			return
+		}
+		select {
+		case files <- root:
+		case <-ctx.Done():
+		}
+		return
+	}
+
+	w := &walker{
+		ctx:      ctx,
+		root:     root,
+		opts:     opts,
+		problems: problems,
+		files:    files,
+		seen:     seen,
+		open:     open,
+		dirs:     dirs,
+		names:    names,
+	}
+	if opts.Gitignore || len(ignoreRules) > 0 {
+		// each root gets its own copy, as .gitignore files add to it
+		w.ignore = &ignoreMatcher{rules: append([]ignoreRule(nil), ignoreRules...)}
+	}
+	if opts.Follow {
+		w.visited = make(map[string]bool)
+	}
+
+	w.visit(root, fs.FileInfoToDirEntry(info))
+	w.wg.Wait()
+}
+
+// walkDir lists the directory at path and visits each of its entries. It is
+// run on its own goroutine for every directory entered.
+func (w *walker) walkDir(path string) {
+	defer w.wg.Done()
+	if w.ctx.Err() != nil {
+		return
+	}
+
+	select {
+	case w.open <- struct{}{}:
+	case <-w.ctx.Done():
+		return
+	}
+	entries, err := os.ReadDir(path)
+	<-w.open
+
+	// an unreadable directory only loses what could not be listed
+	if err != nil {
+		w.problems.report(fmt.Errorf("error in walking directory: %w", err))
+	}
+	for _, entry := range entries {
+		if !w.visit(filepath.Join(path, entry.Name()), entry) {
+			return
+		}
+	}
+}
+
+// visit decides what to do with the entry d at path: directories are walked
+// on a new goroutine and wanted files are sent to the workers. It returns
+// false once the walk is cancelled.
+func (w *walker) visit(path string, d fs.DirEntry) bool {
+	if w.ctx.Err() != nil {
+		return false
+	}
+
+	relPath, err := filepath.Rel(w.root, path)
+	if err != nil {
+		w.problems.report(fmt.Errorf("error in walking directory: %w", err))
+		return true
+	}
+	// the name is taken from path, as a followed link is reported under
+	// its own name rather than its target's
+	name := filepath.Base(path)
+	isDir := d.IsDir()
+
+	// hidden directories are pruned as soon as they are reached, so
+	// only the last component of the path needs checking. The root
+	// itself is always searched, whatever its name.
+	if !w.opts.Hidden && relPath != "." && strings.HasPrefix(name, ".") {
+		return true
+	}
+
+	// a file's depth is the number of directories between it and the
+	// root, so a directory is only entered if its files are in range
+	if w.opts.MaxDepth != nil && relPath != "." {
+		depth := len(strings.Split(relPath, string(filepath.Separator))) - 1
+		if isDir && depth+1 > *w.opts.MaxDepth {
+			return true
+		}
+		if !isDir && depth > *w.opts.MaxDepth {
+			return true
+		}
+	}
+
+	if relPath != "." && w.ignore.ignored(relPath, isDir) {
+		return true
+	}
+
+	// info is only fetched once something needs more than the entry type
+	var info fs.FileInfo
+	if w.opts.Follow && d.Type()&fs.ModeSymlink != 0 {
+		target, err := os.Stat(path)
+		if err != nil {
+			w.problems.report(fmt.Errorf("error in following link: %w", err))
+			return true
+		}
+		// listing the link lists its target, with paths kept under the link
+		isDir = target.IsDir()
+		info = target
+	}
+
+	if isDir {
+		// with links followed the same directory can come up again, at
+		// worst as its own descendant
+		if w.visited != nil {
+			resolved, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				w.problems.report(fmt.Errorf("error in walking directory: %w", err))
+				return true
+			}
+			w.mu.Lock()
+			seen := w.visited[resolved]
+			w.visited[resolved] = true
+			w.mu.Unlock()
+			if seen {
+				return true
+			}
+		}
+
+		// pick up the ignore rules of each directory before walking into
+		// it, so they are in place before anything below is visited
+		if w.opts.Gitignore {
+			base := filepath.ToSlash(relPath)
+			if base == "." {
+				base = ""
+			}
+			for _, name := range ignoreFileNames {
+				if err := w.ignore.load(filepath.Join(path, name), base); err != nil {
+					w.problems.report(fmt.Errorf("error in reading ignore file: %w", err))
+				}
+			}
+		}
+
+		if w.dirs != nil {
+			w.dirs(path)
+		}
+		w.wg.Add(1)
+		// in walk order a directory's files are all found before the
+		// next entry, which keeps the order the same from run to run
+		if w.opts.WalkOrder {
+			w.walkDir(path)
+		} else {
+			go w.walkDir(path)
+		}
+		return true
+	}
+
+	if !wantFile(w.opts, name) || !wantPath(w.opts, relPath) {
+		return true
+	}
+	if w.names != nil && !wantName(w.names, w.opts, relPath) {
+		return true
+	}
+
+	// only regular files are searched: opening a FIFO can block a worker
+	// forever and a device such as /dev/zero never ends. A link is judged
+	// by what it points to.
+	if info == nil && d.Type()&fs.ModeSymlink != 0 {
+		if info, err = os.Stat(path); err != nil {
+			w.problems.report(fmt.Errorf("error in opening file: %w", err))
+			return true
+		}
+	}
+	mode := d.Type()
+	if info != nil {
+		mode = info.Mode()
+	}
+	if !mode.IsRegular() {
+		return true
+	}
+
+	if filtersInfo(w.opts) {
+		if info == nil {
+			if info, err = d.Info(); err != nil {
+				w.problems.report(fmt.Errorf("error in walking directory: %w", err))
+				return true
+			}
+		}
+		if !wantInfo(w.opts, info) {
+			return true
+		}
+	}
+
+	if w.seen != nil {
+		if info == nil {
+			if info, err = d.Info(); err != nil {
+				w.problems.report(fmt.Errorf("error in walking directory: %w", err))
+				return true
+			}
+		}
+		if !w.seen.add(path, info) {
+			return true
+		}
+	}
+
+	select {
+	case w.files <- path:
+		return true
+	case <-w.ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// walkTree walks root with opts and returns, relative to root and sorted,
+// the files the walk sends and the directories it enters.
+func walkTree(t *testing.T, root string, opts Options) (files, dirs []string) {
+	t.Helper()
+	opts = scanDefaults(opts)
+	opts.ErrOutput = io.Discard
+	problems := newProblems(opts)
+	found := make(chan string)
+	open := make(chan struct{}, opts.MaxOpenFiles)
+
+	var mu sync.Mutex
+	rel := func(path string) string {
+		r, err := filepath.Rel(root, path)
+		if err != nil {
+			t.Errorf("%s is not under %s", path, root)
+		}
+		return filepath.ToSlash(r)
+	}
+	go walkRoots(context.Background(), []string{root}, opts, nil, found, problems, open, func(dir string) {
+		mu.Lock()
+		defer mu.Unlock()
+		dirs = append(dirs, rel(dir))
+	}, nil)
+	for file := range found {
+		files = append(files, rel(file))
+	}
+	if err := problems.err(); err != nil {
+		t.Fatalf("walking %s: %v", root, err)
+	}
+	slices.Sort(files)
+	slices.Sort(dirs)
+	return files, dirs
+}
+
+// writeFiles creates each of the files named, relative to root, along with
+// the directories they are in.
+func writeFiles(t *testing.T, root string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("pattern\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWalkPrunesHiddenDirectories(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, "main.go", ".git/config", ".git/objects/ab/cdef", "src/.cache/x", "src/lib.go")
+
+	files, dirs := walkTree(t, root, Options{})
+	if want := []string{"main.go", "src/lib.go"}; !slices.Equal(files, want) {
+		t.Errorf("files = %q, want %q", files, want)
+	}
+	// the hidden directories are never entered, not just left unsearched
+	if want := []string{".", "src"}; !slices.Equal(dirs, want) {
+		t.Errorf("entered %q, want %q", dirs, want)
+	}
+}
+
+func TestWalkFollowSymlinkCycle(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, "a/one", "a/b/two")
+	if err := os.Symlink("..", filepath.Join(root, "a", "b", "loop")); err != nil {
+		t.Skipf("cannot make a symlink: %v", err)
+	}
+	// back to the root, which is where the walk started
+	if err := os.Symlink(filepath.Join("..", ".."), filepath.Join(root, "a", "b", "top")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"a/b/two", "a/one"}
+	for _, follow := range []bool{false, true} {
+		files, _ := walkTree(t, root, Options{Follow: follow})
+		if !slices.Equal(files, want) {
+			t.Errorf("Follow %v: files = %q, want each of %q once", follow, files, want)
+		}
+	}
+}
+
+func TestWalkGitignore(t *testing.T) {
+	tests := []struct {
+		name    string
+		ignores map[string]string
+		files   []string
+		want    []string
+	}{
+		{
+			"negation",
+			map[string]string{".gitignore": "*.log\n!keep.log\n"},
+			[]string{"a.log", "keep.log", "sub/b.log", "sub/keep.log"},
+			[]string{"keep.log", "sub/keep.log"},
+		},
+		{
+			"directory only",
+			map[string]string{".gitignore": "build/\n"},
+			[]string{"build/out", "sub/build/out", "other/build", "main.go"},
+			[]string{"main.go", "other/build"},
+		},
+		{
+			"anchored",
+			map[string]string{".gitignore": "/top.txt\n/sub/deep.txt\n"},
+			[]string{"top.txt", "sub/top.txt", "sub/deep.txt", "other/sub/deep.txt"},
+			[]string{"other/sub/deep.txt", "sub/top.txt"},
+		},
+		{
+			"nested",
+			map[string]string{".gitignore": "*.log\n", "sub/.gitignore": "*.txt\n!b.log\n"},
+			[]string{"a.log", "notes.txt", "sub/b.log", "sub/c.log", "sub/notes.txt", "other/notes.txt"},
+			[]string{"notes.txt", "other/notes.txt", "sub/b.log"},
+		},
+		{
+			"nested anchored",
+			map[string]string{"sub/.gitignore": "/x\n"},
+			[]string{"x", "sub/x", "sub/deeper/x"},
+			[]string{"sub/deeper/x", "x"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFiles(t, root, tt.files...)
+			for name, rules := range tt.ignores {
+				path := filepath.Join(root, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			files, _ := walkTree(t, root, Options{Gitignore: true})
+			if !slices.Equal(files, tt.want) {
+				t.Errorf("files = %q, want %q", files, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+//go:build unix
+
+package utils
+
+import (
+	"bytes"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWalkSkipsFIFO(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, "file")
+	if err := syscall.Mkfifo(filepath.Join(root, "fifo"), 0o644); err != nil {
+		t.Skipf("cannot make a FIFO: %v", err)
+	}
+
+	files, _ := walkTree(t, root, Options{})
+	if want := []string{"file"}; !slices.Equal(files, want) {
+		t.Errorf("files = %q, want %q", files, want)
+	}
+
+	// opening the FIFO would block until something writes to it
+	done := make(chan string)
+	go func() {
+		var out bytes.Buffer
+		ConcurrentGrep([]string{"pattern"}, []string{root}, Options{Output: &out, NoFilename: true})
+		done <- out.String()
+	}()
+	select {
+	case got := <-done:
+		if want := "1:pattern\n"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("search did not finish")
+	}
+}
@@ -0,0 +1,234 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch searches roots like ConcurrentGrep, then keeps watching them and
+// searches every file again as it is written to, printing only the lines
+// that had not been printed before. It runs until ctx is cancelled. Files
+// and directories that appear later are picked up, but are only filtered by
+// their names: ignore files don't apply to them. Counting and listing files
+// are not supported, every selected line is printed.
+func Watch(ctx context.Context, patterns []string, roots []string, opts Options) error {
+	// the first search and every one after it share the same matcher
+	s, err := NewSearcher(patterns, opts)
+	if err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error in watching files: %w", err)
+	}
+	defer watcher.Close()
+
+	output := opts.Output
+	if output == nil {
+		output = os.Stdout
+	}
+	w := &watch{
+		ctx:         ctx,
+		opts:        s.opts,
+		finder:      s.finder,
+		ignoreRules: s.ignoreRules,
+		watcher:     watcher,
+		problems:    newProblems(opts),
+		printer:     newPrinter(output, s.opts),
+		printed:     make(map[string]map[string]int),
+	}
+
+	// directories are watched before the first search, so nothing written
+	// in between is missed
+	var paths []string
+	for _, root := range roots {
+		if root != "-" {
+			paths = append(paths, root)
+		}
+	}
+	for _, path := range paths {
+		w.add(path)
+	}
+
+	results, _ := s.search(ctx, paths)
+	for result := range results {
+		if err := w.print(result); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if err := w.handle(event); err != nil {
+				return err
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			w.problems.report(fmt.Errorf("error in watching files: %w", err))
+		}
+	}
+}
+
+// watch is the state of a running Watch.
+type watch struct {
+	ctx         context.Context
+	opts        Options
+	finder      matcher
+	ignoreRules []ignoreRule
+	watcher     *fsnotify.Watcher
+	problems    *problems
+	printer     *printer
+
+	// printed holds, for every file, how many times each line has been
+	// printed, by the key made by printedKey.
+	printed map[string]map[string]int
+}
+
+// add watches every directory the walk would enter under root, and returns
+// the files it would search there.
+func (w *watch) add(root string) []string {
+	files := make(chan string)
+	open := make(chan struct{}, w.opts.MaxOpenFiles)
+	var dirs []string
+	found := make(chan string, 1)
+	go walkRoots(w.ctx, []string{root}, w.opts, w.ignoreRules, files, w.problems, open, func(path string) {
+		found <- path
+	}, nil)
+
+	// the walk reports directories from several goroutines, they are
+	// collected here so the watcher is only used from one
+	var paths []string
+	for files != nil || found != nil {
+		select {
+		case file, ok := <-files:
+			if !ok {
+				files = nil
+				// every directory has been reported once the walk is done
+				close(found)
+				continue
+			}
+			paths = append(paths, file)
+		case dir, ok := <-found:
+			if !ok {
+				found = nil
+				continue
+			}
+			dirs = append(dirs, dir)
+		}
+	}
+
+	for _, dir := range dirs {
+		if err := w.watcher.Add(dir); err != nil {
+			w.problems.report(fmt.Errorf("error in watching directory %s: %w", dir, err))
+		}
+	}
+	return paths
+}
+
+// handle searches again whatever event says has changed.
+func (w *watch) handle(event fsnotify.Event) error {
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		delete(w.printed, event.Name)
+		return nil
+	}
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return nil
+	}
+
+	info, err := os.Stat(event.Name)
+	if err != nil {
+		// it may well be gone again already
+		return nil
+	}
+	if info.IsDir() {
+		if event.Has(fsnotify.Create) && w.wanted(event.Name) {
+			for _, file := range w.add(event.Name) {
+				if err := w.search(file); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if !info.Mode().IsRegular() || !w.wanted(event.Name) || !wantFile(w.opts, filepath.Base(event.Name)) {
+		return nil
+	}
+	if !wantInfo(w.opts, info) {
+		return nil
+	}
+	return w.search(event.Name)
+}
+
+// wanted reports whether path is not skipped as a hidden file.
+func (w *watch) wanted(path string) bool {
+	return w.opts.Hidden || !strings.HasPrefix(filepath.Base(path), ".")
+}
+
+// search searches the file at path and prints what is new in it.
+func (w *watch) search(path string) error {
+	results := make(chan fileResult)
+	go func() {
+		defer close(results)
+		open := make(chan struct{}, 1)
+		searchFile(w.ctx, path, w.finder, w.opts, results, w.problems, open)
+	}()
+	for result := range results {
+		if err := w.print(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// print prints the lines of result that have not been printed before. Lines
+// are told apart by their text rather than their number, so a line inserted
+// above doesn't make every line below it new. A line whose text is repeated
+// is only new once there are more copies of it than were printed.
+func (w *watch) print(result fileResult) error {
+	printed := w.printed[result.file]
+	if printed == nil {
+		printed = make(map[string]int)
+		w.printed[result.file] = printed
+	}
+
+	fresh := fileResult{file: result.file}
+	seen := make(map[string]int)
+	for _, m := range result.matches {
+		key := printedKey(m)
+		seen[key]++
+		if seen[key] <= printed[key] {
+			continue
+		}
+		printed[key] = seen[key]
+		fresh.matches = append(fresh.matches, m)
+	}
+	fresh.count = len(fresh.matches)
+	if fresh.count == 0 {
+		return nil
+	}
+
+	if err := w.printer.print(fresh); err != nil {
+		return err
+	}
+	return w.printer.out.Flush()
+}
+
+// printedKey identifies a reported line within its file, up to copies of it.
+func printedKey(m Match) string {
+	return fmt.Sprintf("%t:%s", m.Context, m.Line)
+}
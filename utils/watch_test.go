@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer that Watch can write to while a test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// waitFor waits until out ends with suffix, failing the test if it takes too
+// long.
+func waitFor(t *testing.T, out *syncBuffer, suffix string) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if strings.HasSuffix(out.String(), suffix) {
+			return
+		}
+	}
+	t.Fatalf("output %q never ended with %q", out.String(), suffix)
+}
+
+func TestWatchInsertAbove(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	if err := os.WriteFile(path, []byte("a\nfoo\nfoo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var out syncBuffer
+	done := make(chan error)
+	go func() {
+		done <- Watch(ctx, []string{"foo"}, []string{dir}, Options{Output: &out, NoFilename: true})
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Watch: %v", err)
+		}
+	}()
+	waitFor(t, &out, "2:foo\n3:foo\n")
+
+	// the lines already printed move down, only the new copy of one of
+	// them and the new line at the end are printed
+	if err := os.WriteFile(path, []byte("new\na\nfoo\nfoo\nfoo\nfoo bar\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, &out, "6:foo bar\n")
+	if got, want := out.String(), "2:foo\n3:foo\n5:foo\n6:foo bar\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}